@@ -0,0 +1,91 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// The JSON API under /.api is what the CLI talks to with -server, it takes
+// the same fields as the forms and has the same permissions.
+
+func apiListHandler(c *gin.Context) {
+	links, err := queryLinks("SELECT " + linkColumns + " FROM links ORDER BY short")
+	if err != nil {
+		c.String(http.StatusInternalServerError, "failed to query: %v", err)
+		return
+	}
+	if links == nil {
+		links = []Link{}
+	}
+
+	c.JSON(http.StatusOK, links)
+}
+
+func apiGetHandler(c *gin.Context) {
+	link, err := getLink(c.Param("s"))
+	if err != nil {
+		linkErr(c, "query", err)
+		return
+	}
+
+	c.JSON(http.StatusOK, link)
+}
+
+func apiCreateHandler(c *gin.Context) {
+	req, ok := bindLinkReq(c)
+	if !ok {
+		return
+	}
+
+	link := req.Link()
+	link.CreatedBy = currentUser(c)
+	if err := insertLink(link); err != nil {
+		linkErr(c, "insert", err)
+		return
+	}
+
+	link, err := getLink(link.Short)
+	if err != nil {
+		linkErr(c, "query", err)
+		return
+	}
+
+	c.JSON(http.StatusCreated, link)
+}
+
+func apiDeleteHandler(c *gin.Context) {
+	link, ok := editableLink(c)
+	if !ok {
+		return
+	}
+
+	// deleting through an alias would take the other names along
+	if link.Short != c.Param("s") {
+		c.String(http.StatusBadRequest, "%s is an alias of go/%s", c.Param("s"), link.Short)
+		return
+	}
+
+	if err := deleteLink(link.ID); err != nil {
+		linkErr(c, "delete", err)
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+func apiStatsHandler(c *gin.Context) {
+	link, err := getLink(c.Param("s"))
+	if err != nil {
+		linkErr(c, "query", err)
+		return
+	}
+
+	stats, err := linkStats(link, days(c, 30))
+	if err != nil {
+		c.String(http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	c.JSON(http.StatusOK, stats)
+}
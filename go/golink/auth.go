@@ -0,0 +1,320 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/coreos/go-oidc/v3/oidc"
+	"github.com/gin-gonic/gin"
+	"golang.org/x/crypto/bcrypt"
+	"golang.org/x/oauth2"
+)
+
+// Authenticator finds out who sent a request. Authenticate returns false
+// after writing a response asking for credentials.
+type Authenticator interface {
+	Authenticate(c *gin.Context) (string, bool)
+}
+
+func newAuthenticator() (Authenticator, error) {
+	switch AuthMode {
+	case "none":
+		return noAuth{}, nil
+	case "proxy":
+		return proxyAuth{header: AuthHeader}, nil
+	case "basic":
+		return newBasicAuth(UsersFile)
+	case "oidc":
+		return newOIDCAuth()
+	default:
+		return nil, fmt.Errorf("unknown auth mode %q", AuthMode)
+	}
+}
+
+// authMiddleware stores the user of the request for currentUser.
+func authMiddleware(a Authenticator) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user, ok := a.Authenticate(c)
+		if !ok {
+			c.Abort()
+			return
+		}
+
+		c.Set("user", user)
+		c.Next()
+	}
+}
+
+func currentUser(c *gin.Context) string {
+	return c.GetString("user")
+}
+
+// isAdmin reports whether user may do everything. Without authentication
+// everyone is.
+func isAdmin(user string) bool {
+	if AuthMode == "none" {
+		return true
+	}
+
+	for _, admin := range strings.Split(Admins, ",") {
+		if admin != "" && strings.TrimSpace(admin) == user {
+			return true
+		}
+	}
+	return false
+}
+
+// canEdit reports whether user may change or delete link.
+func canEdit(user string, link Link) bool {
+	return isAdmin(user) || (user != "" && link.CreatedBy == user)
+}
+
+func requireAdmin(c *gin.Context) {
+	if !isAdmin(currentUser(c)) {
+		c.AbortWithStatus(http.StatusForbidden)
+		return
+	}
+	c.Next()
+}
+
+// noAuth trusts everyone, links are owned by the address they were created
+// from.
+type noAuth struct{}
+
+func (noAuth) Authenticate(c *gin.Context) (string, bool) {
+	return c.ClientIP(), true
+}
+
+// proxyAuth trusts a header set by an authenticating reverse proxy, golink
+// must not be reachable around it.
+type proxyAuth struct {
+	header string
+}
+
+func (a proxyAuth) Authenticate(c *gin.Context) (string, bool) {
+	user := c.GetHeader(a.header)
+	if user == "" {
+		c.String(http.StatusUnauthorized, "missing %s header", a.header)
+		return "", false
+	}
+	return user, true
+}
+
+// basicAuth checks HTTP basic auth against a users file of "user:bcrypt
+// hash" lines, as written by `htpasswd -B`.
+type basicAuth struct {
+	users map[string][]byte
+}
+
+func newBasicAuth(path string) (basicAuth, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return basicAuth{}, err
+	}
+	defer f.Close()
+
+	a := basicAuth{users: make(map[string][]byte)}
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		user, hash, ok := strings.Cut(line, ":")
+		if !ok || !strings.HasPrefix(hash, "$2") {
+			return basicAuth{}, fmt.Errorf("invalid line in %s, want user:bcrypt-hash", path)
+		}
+		a.users[user] = []byte(hash)
+	}
+
+	return a, scanner.Err()
+}
+
+func (a basicAuth) Authenticate(c *gin.Context) (string, bool) {
+	user, password, ok := c.Request.BasicAuth()
+	if ok {
+		hash, known := a.users[user]
+		if known && bcrypt.CompareHashAndPassword(hash, []byte(password)) == nil {
+			return user, true
+		}
+	}
+
+	c.Header("WWW-Authenticate", `Basic realm="golink"`)
+	c.String(http.StatusUnauthorized, "unauthorized")
+	return "", false
+}
+
+const (
+	sessionCookie = "golink_session"
+	stateCookie   = "golink_state"
+	sessionTTL    = 24 * time.Hour
+)
+
+// oidcAuth logs users in with the authorization code flow of an OpenID
+// Connect issuer and keeps them in a signed session cookie. The signing key
+// is made at startup, a restart only means another round trip to the issuer.
+type oidcAuth struct {
+	config   oauth2.Config
+	verifier *oidc.IDTokenVerifier
+	claim    string
+	key      []byte
+}
+
+func newOIDCAuth() (*oidcAuth, error) {
+	if OIDCIssuer == "" || OIDCClientID == "" || OIDCRedirectURL == "" {
+		return nil, errors.New("oidc needs -oidc-issuer, -oidc-client-id and -oidc-redirect-url")
+	}
+
+	provider, err := oidc.NewProvider(context.Background(), OIDCIssuer)
+	if err != nil {
+		return nil, err
+	}
+
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		return nil, err
+	}
+
+	return &oidcAuth{
+		config: oauth2.Config{
+			ClientID:     OIDCClientID,
+			ClientSecret: OIDCClientSecret,
+			RedirectURL:  OIDCRedirectURL,
+			Endpoint:     provider.Endpoint(),
+			Scopes:       []string{oidc.ScopeOpenID, "profile", "email"},
+		},
+		verifier: provider.Verifier(&oidc.Config{ClientID: OIDCClientID}),
+		claim:    OIDCClaim,
+		key:      key,
+	}, nil
+}
+
+func (a *oidcAuth) Authenticate(c *gin.Context) (string, bool) {
+	if cookie, err := c.Cookie(sessionCookie); err == nil {
+		if user, ok := a.verifySession(cookie); ok {
+			return user, true
+		}
+	}
+
+	if c.Request.Method != http.MethodGet {
+		c.String(http.StatusUnauthorized, "unauthorized")
+		return "", false
+	}
+
+	// remember where to go back to with the state
+	state := randomString() + "." + base64.RawURLEncoding.EncodeToString([]byte(c.Request.URL.RequestURI()))
+	c.SetCookie(stateCookie, state, 600, "/", "", secureCookies(c), true)
+	c.Redirect(http.StatusFound, a.config.AuthCodeURL(state))
+	return "", false
+}
+
+// callbackHandler finishes a login, it is served without authentication.
+func (a *oidcAuth) callbackHandler(c *gin.Context) {
+	state, err := c.Cookie(stateCookie)
+	if err != nil || state != c.Query("state") {
+		c.String(http.StatusBadRequest, "invalid login state")
+		return
+	}
+	c.SetCookie(stateCookie, "", -1, "/", "", secureCookies(c), true)
+
+	token, err := a.config.Exchange(c, c.Query("code"))
+	if err != nil {
+		c.String(http.StatusBadGateway, "failed to exchange code: %v", err)
+		return
+	}
+
+	rawIDToken, ok := token.Extra("id_token").(string)
+	if !ok {
+		c.String(http.StatusBadGateway, "no id_token in token response")
+		return
+	}
+
+	idToken, err := a.verifier.Verify(c, rawIDToken)
+	if err != nil {
+		c.String(http.StatusUnauthorized, "failed to verify id_token: %v", err)
+		return
+	}
+
+	var claims map[string]any
+	if err := idToken.Claims(&claims); err != nil {
+		c.String(http.StatusBadGateway, "failed to parse claims: %v", err)
+		return
+	}
+
+	user, _ := claims[a.claim].(string)
+	if user == "" {
+		c.String(http.StatusUnauthorized, "id_token has no %s claim", a.claim)
+		return
+	}
+
+	c.SetCookie(sessionCookie, a.signSession(user), int(sessionTTL.Seconds()), "/", "", secureCookies(c), true)
+
+	returnTo := "/"
+	if _, encoded, ok := strings.Cut(state, "."); ok {
+		if uri, err := base64.RawURLEncoding.DecodeString(encoded); err == nil && strings.HasPrefix(string(uri), "/") && !strings.HasPrefix(string(uri), "//") {
+			returnTo = string(uri)
+		}
+	}
+	c.Redirect(http.StatusFound, returnTo)
+}
+
+// signSession returns base64(user).expiry.mac.
+func (a *oidcAuth) signSession(user string) string {
+	payload := base64.RawURLEncoding.EncodeToString([]byte(user)) + "." +
+		strconv.FormatInt(time.Now().Add(sessionTTL).Unix(), 10)
+	return payload + "." + a.mac(payload)
+}
+
+func (a *oidcAuth) verifySession(cookie string) (string, bool) {
+	i := strings.LastIndexByte(cookie, '.')
+	if i < 0 || !hmac.Equal([]byte(cookie[i+1:]), []byte(a.mac(cookie[:i]))) {
+		return "", false
+	}
+
+	encoded, expiry, _ := strings.Cut(cookie[:i], ".")
+	exp, err := strconv.ParseInt(expiry, 10, 64)
+	if err != nil || time.Now().Unix() > exp {
+		return "", false
+	}
+
+	user, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return "", false
+	}
+	return string(user), true
+}
+
+func (a *oidcAuth) mac(payload string) string {
+	h := hmac.New(sha256.New, a.key)
+	h.Write([]byte(payload))
+	return base64.RawURLEncoding.EncodeToString(h.Sum(nil))
+}
+
+func logoutHandler(c *gin.Context) {
+	c.SetCookie(sessionCookie, "", -1, "/", "", secureCookies(c), true)
+	c.String(http.StatusOK, "logged out")
+}
+
+// secureCookies tells whether cookies are only sent back over HTTPS, when
+// golink serves TLS itself or -secure-cookies says a proxy does.
+func secureCookies(c *gin.Context) bool {
+	return c.Request.TLS != nil || SecureCookies
+}
+
+func randomString() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
@@ -0,0 +1,334 @@
+package main
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func init() {
+	gin.SetMode(gin.TestMode)
+}
+
+// whoami answers with the user the authenticator found.
+func whoami(a Authenticator) *gin.Engine {
+	r := gin.New()
+	if oa, ok := a.(*oidcAuth); ok {
+		r.GET("/.auth/callback", oa.callbackHandler)
+	}
+	r.Use(authMiddleware(a))
+	r.GET("/:short", func(c *gin.Context) {
+		c.String(http.StatusOK, currentUser(c))
+	})
+	return r
+}
+
+func record(r http.Handler, req *http.Request) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	return w
+}
+
+func TestBasicAuth(t *testing.T) {
+	hash, err := bcrypt.GenerateFromPassword([]byte("secret"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	users := filepath.Join(t.TempDir(), "users")
+	if err := os.WriteFile(users, []byte("# htpasswd -B\nalice:"+string(hash)+"\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	a, err := newBasicAuth(users)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := whoami(a)
+
+	tests := []struct {
+		name           string
+		user, password string
+		wantCode       int
+		wantBody       string
+	}{
+		{"no credentials", "", "", http.StatusUnauthorized, "unauthorized"},
+		{"wrong password", "alice", "guess", http.StatusUnauthorized, "unauthorized"},
+		{"unknown user", "bob", "secret", http.StatusUnauthorized, "unauthorized"},
+		{"ok", "alice", "secret", http.StatusOK, "alice"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/x", nil)
+			if tt.user != "" {
+				req.SetBasicAuth(tt.user, tt.password)
+			}
+
+			w := record(r, req)
+			if w.Code != tt.wantCode || w.Body.String() != tt.wantBody {
+				t.Errorf("got %d %q, want %d %q", w.Code, w.Body.String(), tt.wantCode, tt.wantBody)
+			}
+			if w.Code == http.StatusUnauthorized && w.Header().Get("WWW-Authenticate") == "" {
+				t.Error("no WWW-Authenticate header")
+			}
+		})
+	}
+}
+
+func TestBasicAuthInvalidFile(t *testing.T) {
+	users := filepath.Join(t.TempDir(), "users")
+	if err := os.WriteFile(users, []byte("alice:plaintext\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := newBasicAuth(users); err == nil {
+		t.Error("newBasicAuth accepted a password that is not a bcrypt hash")
+	}
+}
+
+func TestSession(t *testing.T) {
+	a := &oidcAuth{key: []byte("0123456789abcdef0123456789abcdef")}
+	other := &oidcAuth{key: []byte("fedcba9876543210fedcba9876543210")}
+
+	cookie := a.signSession("alice@example.com")
+	if user, ok := a.verifySession(cookie); !ok || user != "alice@example.com" {
+		t.Fatalf("verifySession(signSession) = %q, %v", user, ok)
+	}
+
+	// alice's expiry and mac with bob's name
+	forged := base64.RawURLEncoding.EncodeToString([]byte("bob@example.com")) + cookie[strings.IndexByte(cookie, '.'):]
+
+	expiredPayload := base64.RawURLEncoding.EncodeToString([]byte("alice@example.com")) + "." +
+		strconv.FormatInt(time.Now().Add(-time.Minute).Unix(), 10)
+	expired := expiredPayload + "." + a.mac(expiredPayload)
+
+	for name, cookie := range map[string]string{
+		"forged user":   forged,
+		"other key":     other.signSession("alice@example.com"),
+		"expired":       expired,
+		"no mac":        "YWxpY2U",
+		"garbage":       "a.b.c",
+		"empty":         "",
+		"truncated mac": cookie[:len(cookie)-2],
+	} {
+		if user, ok := a.verifySession(cookie); ok {
+			t.Errorf("%s: verifySession accepted %q as %q", name, cookie, user)
+		}
+	}
+}
+
+// fakeIssuer is an OpenID Connect issuer for the authorization code flow:
+// discovery, keys and a token endpoint trading code for an ID token with
+// the email claim.
+type fakeIssuer struct {
+	*httptest.Server
+	key      *rsa.PrivateKey
+	clientID string
+	code     string
+	email    string
+}
+
+func newFakeIssuer(t *testing.T, clientID string) *fakeIssuer {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	iss := &fakeIssuer{key: key, clientID: clientID, code: "good-code", email: "alice@example.com"}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]any{
+			"issuer":                                iss.URL,
+			"authorization_endpoint":                iss.URL + "/auth",
+			"token_endpoint":                        iss.URL + "/token",
+			"jwks_uri":                              iss.URL + "/keys",
+			"id_token_signing_alg_values_supported": []string{"RS256"},
+		})
+	})
+	mux.HandleFunc("/keys", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]any{"keys": []map[string]string{{
+			"kty": "RSA",
+			"alg": "RS256",
+			"use": "sig",
+			"kid": "test",
+			"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+		}}})
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		if r.FormValue("code") != iss.code {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(`{"error":"invalid_grant"}`))
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]any{
+			"access_token": "access",
+			"token_type":   "Bearer",
+			"expires_in":   3600,
+			"id_token":     iss.idToken(t),
+		})
+	})
+
+	iss.Server = httptest.NewServer(mux)
+	t.Cleanup(iss.Close)
+	return iss
+}
+
+func (iss *fakeIssuer) idToken(t *testing.T) string {
+	enc := func(v any) string {
+		b, err := json.Marshal(v)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return base64.RawURLEncoding.EncodeToString(b)
+	}
+
+	now := time.Now()
+	signed := enc(map[string]string{"alg": "RS256", "kid": "test", "typ": "JWT"}) + "." + enc(map[string]any{
+		"iss":   iss.URL,
+		"aud":   iss.clientID,
+		"sub":   "1",
+		"email": iss.email,
+		"iat":   now.Unix(),
+		"exp":   now.Add(time.Hour).Unix(),
+	})
+
+	digest := sha256.Sum256([]byte(signed))
+	sig, err := rsa.SignPKCS1v15(rand.Reader, iss.key, crypto.SHA256, digest[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signed + "." + base64.RawURLEncoding.EncodeToString(sig)
+}
+
+// withOIDC points the OIDC flags at iss for the test.
+func withOIDC(t *testing.T, iss *fakeIssuer) *oidcAuth {
+	saved := []string{OIDCIssuer, OIDCClientID, OIDCClientSecret, OIDCRedirectURL, OIDCClaim}
+	t.Cleanup(func() {
+		OIDCIssuer, OIDCClientID, OIDCClientSecret, OIDCRedirectURL, OIDCClaim = saved[0], saved[1], saved[2], saved[3], saved[4]
+	})
+	OIDCIssuer, OIDCClientID, OIDCClientSecret = iss.URL, iss.clientID, "secret"
+	OIDCRedirectURL, OIDCClaim = "http://go/.auth/callback", "email"
+
+	a, err := newOIDCAuth()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return a
+}
+
+func cookieOf(w *httptest.ResponseRecorder, name string) *http.Cookie {
+	for _, c := range w.Result().Cookies() {
+		if c.Name == name {
+			return c
+		}
+	}
+	return nil
+}
+
+func TestOIDCLogin(t *testing.T) {
+	iss := newFakeIssuer(t, "golink")
+	r := whoami(withOIDC(t, iss))
+
+	// not logged in: off to the issuer, with the page to come back to
+	w := record(r, httptest.NewRequest(http.MethodGet, "/docs?q=1", nil))
+	if w.Code != http.StatusFound || !strings.HasPrefix(w.Header().Get("Location"), iss.URL+"/auth?") {
+		t.Fatalf("got %d to %q, want a redirect to the issuer", w.Code, w.Header().Get("Location"))
+	}
+	login, err := url.Parse(w.Header().Get("Location"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	state := login.Query().Get("state")
+	stateCookie := cookieOf(w, stateCookie)
+	if stateCookie == nil || stateCookie.Value != state {
+		t.Fatalf("state cookie %v does not match state %q", stateCookie, state)
+	}
+
+	callback := func(code, state string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/.auth/callback?"+url.Values{"code": {code}, "state": {state}}.Encode(), nil)
+		req.AddCookie(stateCookie)
+		return record(r, req)
+	}
+
+	if w := callback(iss.code, "other"); w.Code != http.StatusBadRequest {
+		t.Errorf("callback with another state: got %d, want 400", w.Code)
+	}
+	if w := callback("bad-code", state); w.Code != http.StatusBadGateway {
+		t.Errorf("callback with a bad code: got %d, want 502", w.Code)
+	}
+
+	w = callback(iss.code, state)
+	if w.Code != http.StatusFound || w.Header().Get("Location") != "/docs?q=1" {
+		t.Fatalf("callback: got %d to %q, want a redirect to /docs?q=1: %s", w.Code, w.Header().Get("Location"), w.Body)
+	}
+	session := cookieOf(w, sessionCookie)
+	if session == nil {
+		t.Fatal("no session cookie")
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/docs", nil)
+	req.AddCookie(session)
+	if w := record(r, req); w.Code != http.StatusOK || w.Body.String() != iss.email {
+		t.Errorf("with the session: got %d %q, want 200 %q", w.Code, w.Body.String(), iss.email)
+	}
+
+	// no redirects for what browsers don't navigate to
+	if w := record(r, httptest.NewRequest(http.MethodPost, "/docs", nil)); w.Code != http.StatusUnauthorized {
+		t.Errorf("POST without a session: got %d, want 401", w.Code)
+	}
+}
+
+func TestSecureCookies(t *testing.T) {
+	r := whoami(withOIDC(t, newFakeIssuer(t, "golink")))
+
+	tests := []struct {
+		name  string
+		tls   bool
+		proxy bool
+		want  bool
+	}{
+		{"http", false, false, false},
+		{"https", true, false, true},
+		{"behind a TLS proxy", false, true, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			saved := SecureCookies
+			SecureCookies = tt.proxy
+			defer func() { SecureCookies = saved }()
+
+			req := httptest.NewRequest(http.MethodGet, "/docs", nil)
+			if tt.tls {
+				req.TLS = &tls.ConnectionState{}
+			}
+
+			c := cookieOf(record(r, req), stateCookie)
+			if c == nil {
+				t.Fatal("no state cookie")
+			}
+			if c.Secure != tt.want || !c.HttpOnly {
+				t.Errorf("Secure = %v, HttpOnly = %v, want Secure %v and HttpOnly", c.Secure, c.HttpOnly, tt.want)
+			}
+		})
+	}
+}
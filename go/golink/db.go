@@ -0,0 +1,311 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"modernc.org/sqlite"
+	sqlite3 "modernc.org/sqlite/lib"
+)
+
+// migrations are applied in order and tracked with PRAGMA user_version, only
+// ever append to it.
+var migrations = []string{
+	SQLSchema,
+
+	// duplicates were possible before the unique index, keep the newest row
+	// and give it the redirects of the others
+	`UPDATE links SET redirects = (SELECT SUM(redirects) FROM links l WHERE l.short = links.short)
+		WHERE id IN (SELECT MAX(id) FROM links GROUP BY short);
+	DELETE FROM links WHERE id NOT IN (SELECT MAX(id) FROM links GROUP BY short);
+	CREATE UNIQUE INDEX links_short ON links (short);
+	ALTER TABLE links ADD COLUMN created_by TEXT NOT NULL DEFAULT '';
+	ALTER TABLE links ADD COLUMN created_at INTEGER NOT NULL DEFAULT 0;
+	ALTER TABLE links ADD COLUMN updated_at INTEGER NOT NULL DEFAULT 0;`,
+
+	`CREATE TABLE clicks (
+		link INTEGER NOT NULL,
+		ts INTEGER NOT NULL,
+		referrer TEXT NOT NULL DEFAULT '',
+		user_agent TEXT NOT NULL DEFAULT ''
+	);
+	CREATE INDEX clicks_link_ts ON clicks (link, ts);`,
+
+	`ALTER TABLE links ADD COLUMN description TEXT NOT NULL DEFAULT ''`,
+
+	`CREATE TABLE aliases (
+		alias TEXT PRIMARY KEY,
+		link INTEGER NOT NULL
+	);
+	CREATE INDEX aliases_link ON aliases (link);
+	ALTER TABLE links ADD COLUMN check_status INTEGER NOT NULL DEFAULT 0;
+	ALTER TABLE links ADD COLUMN check_error TEXT NOT NULL DEFAULT '';
+	ALTER TABLE links ADD COLUMN checked_at INTEGER NOT NULL DEFAULT 0;`,
+}
+
+func migrate(db *sql.DB) error {
+	var version int
+	if err := db.QueryRow("PRAGMA user_version").Scan(&version); err != nil {
+		return err
+	}
+
+	for i := version; i < len(migrations); i++ {
+		tx, err := db.Begin()
+		if err != nil {
+			return err
+		}
+
+		if _, err := tx.Exec(migrations[i]); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("migration %d: %w", i+1, err)
+		}
+
+		if _, err := tx.Exec(fmt.Sprintf("PRAGMA user_version = %d", i+1)); err != nil {
+			tx.Rollback()
+			return err
+		}
+
+		if err := tx.Commit(); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+var (
+	errNotFound = errors.New("link not found")
+	errConflict = errors.New("already exists")
+)
+
+const linkColumns = `id, short, long, description, redirects, created_by, created_at, updated_at,
+	IFNULL((SELECT GROUP_CONCAT(alias, ',') FROM aliases WHERE aliases.link = links.id), ''),
+	check_status, check_error, checked_at`
+
+type scanner interface {
+	Scan(dest ...any) error
+}
+
+// scanLink scans the linkColumns of a row, followed by extra columns if any.
+func scanLink(s scanner, extra ...any) (Link, error) {
+	var (
+		link                      Link
+		created, updated, checked int64
+		aliases                   string
+	)
+	dest := append([]any{&link.ID, &link.Short, &link.Long, &link.Description, &link.Redirects, &link.CreatedBy,
+		&created, &updated, &aliases, &link.CheckStatus, &link.CheckError, &checked}, extra...)
+	err := s.Scan(dest...)
+	if err != nil {
+		return link, err
+	}
+
+	if created > 0 {
+		link.CreatedAt = time.Unix(created, 0)
+	}
+	if updated > 0 {
+		link.UpdatedAt = time.Unix(updated, 0)
+	}
+	if checked > 0 {
+		link.CheckedAt = time.Unix(checked, 0)
+	}
+	if aliases != "" {
+		link.Aliases = strings.Split(aliases, ",")
+		sort.Strings(link.Aliases)
+	}
+
+	return link, nil
+}
+
+func queryLinks(query string, args ...any) ([]Link, error) {
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var links []Link
+	for rows.Next() {
+		link, err := scanLink(rows)
+		if err != nil {
+			return nil, err
+		}
+		links = append(links, link)
+	}
+
+	return links, rows.Err()
+}
+
+// getLink returns the link named short or with short as an alias.
+func getLink(short string) (Link, error) {
+	link, err := scanLink(db.QueryRow("SELECT "+linkColumns+" FROM links WHERE short = ?1 OR id = (SELECT link FROM aliases WHERE alias = ?1)", short))
+	if errors.Is(err, sql.ErrNoRows) {
+		return link, errNotFound
+	}
+	return link, err
+}
+
+// withTx runs fn in a transaction, committed if fn succeeds.
+func withTx(fn func(tx *sql.Tx) error) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := fn(tx); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func insertLink(link Link) error {
+	return withTx(func(tx *sql.Tx) error {
+		return insertLinkTx(tx, link)
+	})
+}
+
+// insertLinkTx adds link, keeping its creation time and redirects if set as
+// for imported links.
+func insertLinkTx(tx *sql.Tx, link Link) error {
+	if err := checkName(tx, link.Short, 0); err != nil {
+		return err
+	}
+
+	now := time.Now().Unix()
+	created, updated := unix(link.CreatedAt), unix(link.UpdatedAt)
+	if created == 0 {
+		created = now
+	}
+	if updated == 0 {
+		updated = created
+	}
+
+	r, err := tx.Exec("INSERT INTO links (short, long, description, redirects, created_by, created_at, updated_at) VALUES (?, ?, ?, ?, ?, ?, ?)",
+		link.Short, link.Long, link.Description, link.Redirects, link.CreatedBy, created, updated)
+	if err != nil {
+		return conflictErr(err, link.Short)
+	}
+
+	id, err := r.LastInsertId()
+	if err != nil {
+		return err
+	}
+
+	return setAliases(tx, int(id), link.Aliases)
+}
+
+func updateLink(id int, link Link) error {
+	return withTx(func(tx *sql.Tx) error {
+		return updateLinkTx(tx, id, link)
+	})
+}
+
+// updateLinkTx changes short, long, description and aliases of the link
+// with the given id. A new long link has to be checked again.
+func updateLinkTx(tx *sql.Tx, id int, link Link) error {
+	if err := checkName(tx, link.Short, id); err != nil {
+		return err
+	}
+
+	r, err := tx.Exec(`UPDATE links SET short = ?, long = ?, description = ?, updated_at = ?,
+		checked_at = CASE WHEN long = ?2 THEN checked_at ELSE 0 END WHERE id = ?`,
+		link.Short, link.Long, link.Description, time.Now().Unix(), id)
+	if err != nil {
+		return conflictErr(err, link.Short)
+	}
+
+	if n, _ := r.RowsAffected(); n == 0 {
+		return errNotFound
+	}
+
+	return setAliases(tx, id, link.Aliases)
+}
+
+// checkName fails if name is an alias of a link other than id.
+func checkName(tx *sql.Tx, name string, id int) error {
+	var n int
+	err := tx.QueryRow("SELECT COUNT(*) FROM aliases WHERE alias = ? AND link != ?", name, id).Scan(&n)
+	if err != nil {
+		return err
+	}
+	if n > 0 {
+		return conflict(name)
+	}
+	return nil
+}
+
+// setAliases replaces the aliases of the link with the given id.
+func setAliases(tx *sql.Tx, id int, aliases []string) error {
+	if _, err := tx.Exec("DELETE FROM aliases WHERE link = ?", id); err != nil {
+		return err
+	}
+
+	for _, alias := range aliases {
+		var n int
+		if err := tx.QueryRow("SELECT COUNT(*) FROM links WHERE short = ?", alias).Scan(&n); err != nil {
+			return err
+		}
+		if n > 0 {
+			return conflict(alias)
+		}
+
+		if _, err := tx.Exec("INSERT INTO aliases (alias, link) VALUES (?, ?)", alias, id); err != nil {
+			return conflictErr(err, alias)
+		}
+	}
+
+	return nil
+}
+
+// deleteLink removes the link with its clicks and aliases.
+func deleteLink(id int) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	r, err := tx.Exec("DELETE FROM links WHERE id = ?", id)
+	if err != nil {
+		return err
+	}
+	if n, _ := r.RowsAffected(); n == 0 {
+		return errNotFound
+	}
+
+	if _, err := tx.Exec("DELETE FROM clicks WHERE link = ?", id); err != nil {
+		return err
+	}
+
+	if _, err := tx.Exec("DELETE FROM aliases WHERE link = ?", id); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// unix stores the zero time as 0.
+func unix(t time.Time) int64 {
+	if t.IsZero() {
+		return 0
+	}
+	return t.Unix()
+}
+
+func conflict(name string) error {
+	return fmt.Errorf("%q %w", name, errConflict)
+}
+
+// conflictErr turns a violation of a unique index on name into errConflict.
+func conflictErr(err error, name string) error {
+	var serr *sqlite.Error
+	if errors.As(err, &serr) && (serr.Code() == sqlite3.SQLITE_CONSTRAINT_UNIQUE || serr.Code() == sqlite3.SQLITE_CONSTRAINT_PRIMARYKEY) {
+		return conflict(name)
+	}
+	return err
+}
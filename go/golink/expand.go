@@ -0,0 +1,138 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"text/template"
+)
+
+// A long link is expanded against the rest of the request path:
+//
+//   - {1}, {2}, ... are replaced by the path segments after the short name,
+//     go/gh/owner/repo with https://github.com/{1}/{2} goes to
+//     https://github.com/owner/repo
+//   - links containing {{ are Go templates, see expandEnv for the fields
+//   - otherwise the rest of the path is appended, go/docs/foo goes to
+//     <docs>/foo
+//
+// The query string of the request is passed through, templates get it as
+// {{.Query}} instead.
+
+// expandEnv is the data of link templates.
+type expandEnv struct {
+	// Path is the rest of the path after the short name, without the
+	// leading slash.
+	Path string
+	// Args are the segments of Path.
+	Args []string
+	// Query is the raw query string of the request.
+	Query string
+}
+
+var (
+	positionalRe = regexp.MustCompile(`\{(\d+)\}`)
+
+	expandFuncs = template.FuncMap{
+		"PathEscape":  url.PathEscape,
+		"QueryEscape": url.QueryEscape,
+		"TrimPrefix":  strings.TrimPrefix,
+		"TrimSuffix":  strings.TrimSuffix,
+		"ToLower":     strings.ToLower,
+		"ToUpper":     strings.ToUpper,
+	}
+)
+
+func isTemplate(long string) bool {
+	return strings.Contains(long, "{{")
+}
+
+func parseLinkTemplate(long string) (*template.Template, error) {
+	return template.New("").Funcs(expandFuncs).Option("missingkey=error").Parse(long)
+}
+
+// expand returns where long redirects for the given rest of the path, which
+// is still escaped, and raw query.
+func expand(long, rest, query string) (string, error) {
+	rest = strings.Trim(rest, "/")
+
+	var args []string
+	if rest != "" {
+		args = strings.Split(rest, "/")
+	}
+
+	target := long
+	positional := positionalRe.MatchString(long)
+	if positional {
+		var missing int
+		target = positionalRe.ReplaceAllStringFunc(long, func(m string) string {
+			n, _ := strconv.Atoi(m[1 : len(m)-1])
+			if n < 1 || n > len(args) {
+				missing = n
+				return ""
+			}
+			return args[n-1]
+		})
+		if missing > 0 {
+			return "", fmt.Errorf("link needs at least %d path segments", missing)
+		}
+	}
+
+	switch {
+	case isTemplate(target):
+		tmpl, err := parseLinkTemplate(target)
+		if err != nil {
+			return "", err
+		}
+
+		path, err := url.PathUnescape(rest)
+		if err != nil {
+			return "", err
+		}
+		env := expandEnv{Path: path, Query: query}
+		for _, a := range args {
+			a, _ = url.PathUnescape(a)
+			env.Args = append(env.Args, a)
+		}
+
+		var sb strings.Builder
+		if err := tmpl.Execute(&sb, env); err != nil {
+			return "", err
+		}
+		target = sb.String()
+	case !positional && rest != "":
+		target = appendPath(target, rest)
+	}
+
+	if query != "" && !isTemplate(long) {
+		target = appendQuery(target, query)
+	}
+
+	return target, nil
+}
+
+// appendPath adds path to the path of target, before its query or fragment.
+func appendPath(target, path string) string {
+	suffix := ""
+	if i := strings.IndexAny(target, "?#"); i >= 0 {
+		target, suffix = target[:i], target[i:]
+	}
+
+	return strings.TrimSuffix(target, "/") + "/" + path + suffix
+}
+
+func appendQuery(target, query string) string {
+	fragment := ""
+	if i := strings.IndexByte(target, '#'); i >= 0 {
+		target, fragment = target[:i], target[i:]
+	}
+
+	sep := "?"
+	if strings.Contains(target, "?") {
+		sep = "&"
+	}
+
+	return target + sep + query + fragment
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const checkWorkers = 4
+
+var checkClient = &http.Client{
+	Timeout: 10 * time.Second,
+}
+
+// Broken reports whether the last health check of the link failed.
+func (l Link) Broken() bool {
+	return !l.CheckedAt.IsZero() && (l.CheckError != "" || l.CheckStatus >= 400)
+}
+
+// checkable reports whether Long can be requested as is, links with
+// placeholders only make sense expanded.
+func (l Link) checkable() bool {
+	return !isTemplate(l.Long) && !positionalRe.MatchString(l.Long)
+}
+
+// checkLinks checks every link every interval, starting with the ones
+// checked the longest time ago.
+func checkLinks(interval time.Duration) {
+	for {
+		if err := checkDue(interval); err != nil {
+			log.Printf("failed to check links: %v", err)
+		}
+		time.Sleep(time.Minute)
+	}
+}
+
+func checkDue(interval time.Duration) error {
+	links, err := queryLinks("SELECT "+linkColumns+" FROM links WHERE checked_at < ? ORDER BY checked_at",
+		time.Now().Add(-interval).Unix())
+	if err != nil {
+		return err
+	}
+
+	jobs := make(chan Link)
+	var wg sync.WaitGroup
+	for i := 0; i < checkWorkers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for link := range jobs {
+				status, errMsg := checkURL(link.Long)
+				if err := saveCheck(link.ID, status, errMsg); err != nil {
+					log.Printf("failed to save check of %s: %v", link.Short, err)
+				}
+			}
+		}()
+	}
+
+	checked := 0
+	for _, link := range links {
+		if !link.checkable() {
+			// stamped without a result, or it would be due again next time
+			if err := saveCheck(link.ID, 0, ""); err != nil {
+				log.Printf("failed to save check of %s: %v", link.Short, err)
+			}
+			continue
+		}
+		jobs <- link
+		checked++
+	}
+	close(jobs)
+	wg.Wait()
+
+	if checked > 0 {
+		broken, err := countBroken()
+		if err != nil {
+			return err
+		}
+		log.Printf("checked %d links, %d broken in total", checked, broken)
+	}
+
+	return nil
+}
+
+// checkURL sends a HEAD request to u, and a GET if the server doesn't like
+// HEAD, returning the status or the error.
+func checkURL(u string) (int, string) {
+	status, err := request(http.MethodHead, u)
+	if err != nil || status >= 400 {
+		status, err = request(http.MethodGet, u)
+	}
+
+	if err != nil {
+		return 0, err.Error()
+	}
+	return status, ""
+}
+
+func request(method, u string) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), checkClient.Timeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, method, u, nil)
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("User-Agent", "golink-checker")
+
+	resp, err := checkClient.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	resp.Body.Close()
+
+	return resp.StatusCode, nil
+}
+
+func saveCheck(id int, status int, errMsg string) error {
+	_, err := db.Exec("UPDATE links SET check_status = ?, check_error = ?, checked_at = ? WHERE id = ?",
+		status, errMsg, time.Now().Unix(), id)
+	return err
+}
+
+func countBroken() (int, error) {
+	var n int
+	err := db.QueryRow("SELECT COUNT(*) FROM links WHERE checked_at > 0 AND (check_error != '' OR check_status >= 400)").Scan(&n)
+	return n, err
+}
+
+type BrokenLink struct {
+	Short     string    `json:"short"`
+	Long      string    `json:"long"`
+	Status    int       `json:"status,omitempty"`
+	Error     string    `json:"error,omitempty"`
+	CheckedAt time.Time `json:"checked_at"`
+	CreatedBy string    `json:"created_by"`
+}
+
+// brokenHandler reports the broken links as JSON.
+func brokenHandler(c *gin.Context) {
+	links, err := queryLinks("SELECT " + linkColumns + " FROM links WHERE checked_at > 0 AND (check_error != '' OR check_status >= 400) ORDER BY short")
+	if err != nil {
+		c.String(http.StatusInternalServerError, "failed to query: %v", err)
+		return
+	}
+
+	report := []BrokenLink{}
+	for _, link := range links {
+		report = append(report, BrokenLink{
+			Short:     link.Short,
+			Long:      link.Long,
+			Status:    link.CheckStatus,
+			Error:     link.CheckError,
+			CheckedAt: link.CheckedAt,
+			CreatedBy: link.CreatedBy,
+		})
+	}
+
+	c.JSON(http.StatusOK, report)
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+)
+
+const (
+	hostsBegin = "# BEGIN golink"
+	hostsEnd   = "# END golink"
+)
+
+// runHosts manages the entry pointing go at golink in a hosts file. The
+// entry lives between marker comments so adding is idempotent and removing
+// leaves the rest of the file alone.
+func runHosts(args []string) {
+	fs := flag.NewFlagSet("hosts", flag.ExitOnError)
+	file := fs.String("file", "/etc/hosts", "hosts file")
+	ip := fs.String("ip", "127.0.0.1", "address golink listens on")
+	name := fs.String("name", "go", "host name of golink")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: golink hosts [flags] add|remove|status")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	if fs.NArg() != 1 {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	raw, err := os.ReadFile(*file)
+	if err != nil {
+		log.Fatalf("failed to read hosts file: %v", err)
+	}
+
+	var updated []byte
+	switch fs.Arg(0) {
+	case "add":
+		updated = addHostsEntry(raw, *ip+" "+*name)
+	case "remove":
+		updated = removeHostsEntry(raw)
+	case "status":
+		if entry, ok := hostsEntry(raw); ok {
+			fmt.Println(entry)
+		} else {
+			fmt.Println("no golink entry in", *file)
+		}
+		return
+	default:
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	if bytes.Equal(raw, updated) {
+		return
+	}
+
+	if err := writeHosts(*file, updated); err != nil {
+		log.Fatalf("failed to write hosts file: %v", err)
+	}
+}
+
+// addHostsEntry puts entry in the golink block, replacing a previous one.
+func addHostsEntry(raw []byte, entry string) []byte {
+	out := removeHostsEntry(raw)
+	if len(out) > 0 && out[len(out)-1] != '\n' {
+		out = append(out, '\n')
+	}
+
+	return append(out, hostsBegin+"\n"+entry+"\n"+hostsEnd+"\n"...)
+}
+
+// removeHostsEntry drops the golink block, and the line older versions
+// appended without a newline.
+func removeHostsEntry(raw []byte) []byte {
+	raw = bytes.TrimSuffix(raw, []byte("127.0.0.1 go"))
+
+	var (
+		out     []byte
+		inBlock bool
+	)
+	for _, line := range strings.SplitAfter(string(raw), "\n") {
+		switch strings.TrimSpace(line) {
+		case hostsBegin:
+			inBlock = true
+			continue
+		case hostsEnd:
+			inBlock = false
+			continue
+		}
+
+		if !inBlock {
+			out = append(out, line...)
+		}
+	}
+
+	return out
+}
+
+func hostsEntry(raw []byte) (string, bool) {
+	_, block, ok := strings.Cut(string(raw), hostsBegin+"\n")
+	if !ok {
+		return "", false
+	}
+
+	entry, _, ok := strings.Cut(block, "\n"+hostsEnd)
+	return entry, ok
+}
+
+// writeHosts rewrites the file in place, it is often a bind mount that
+// can't be replaced by a rename.
+func writeHosts(path string, data []byte) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(path, data, info.Mode().Perm())
+}
@@ -0,0 +1,211 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+type LinkReq struct {
+	Short       string `form:"short" json:"short" binding:"required"`
+	Long        string `form:"long" json:"long" binding:"required"`
+	Description string `form:"description" json:"description"`
+	// Aliases are separated by commas or spaces.
+	Aliases string `form:"aliases" json:"aliases"`
+}
+
+func (req LinkReq) Link() Link {
+	return Link{Short: req.Short, Long: req.Long, Description: req.Description, Aliases: req.aliases()}
+}
+
+func (req LinkReq) aliases() []string {
+	var aliases []string
+	for _, alias := range strings.FieldsFunc(req.Aliases, func(r rune) bool { return r == ',' || r == ' ' }) {
+		if alias != req.Short && !contains(aliases, alias) {
+			aliases = append(aliases, alias)
+		}
+	}
+	return aliases
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+func (req LinkReq) validate() error {
+	if err := validateName(req.Short); err != nil {
+		return err
+	}
+
+	for _, alias := range req.aliases() {
+		if err := validateName(alias); err != nil {
+			return fmt.Errorf("alias %q: %v", alias, err)
+		}
+	}
+
+	if len(req.Long) > 500 {
+		return errors.New("long link too long")
+	}
+
+	if len(req.Description) > 500 {
+		return errors.New("description too long")
+	}
+
+	if isTemplate(req.Long) {
+		if _, err := parseLinkTemplate(req.Long); err != nil {
+			return fmt.Errorf("invalid link template: %v", err)
+		}
+	}
+
+	return nil
+}
+
+func validateName(name string) error {
+	if len(name) > 32 {
+		return errors.New("short link too long")
+	}
+
+	// names starting with a dot are reserved for our own pages
+	if strings.HasPrefix(name, ".") || strings.Contains(name, "/") {
+		return errors.New("short link can't start with '.' or contain '/'")
+	}
+
+	return nil
+}
+
+func bindLinkReq(c *gin.Context) (LinkReq, bool) {
+	var req LinkReq
+	if err := c.ShouldBind(&req); err != nil {
+		c.String(http.StatusBadRequest, "missing short or long link")
+		return req, false
+	}
+
+	if err := req.validate(); err != nil {
+		c.String(http.StatusBadRequest, err.Error())
+		return req, false
+	}
+
+	return req, true
+}
+
+// linkErr writes the response for an error of the link queries.
+func linkErr(c *gin.Context, action string, err error) {
+	switch {
+	case errors.Is(err, errNotFound):
+		c.String(http.StatusNotFound, "not found")
+	case errors.Is(err, errConflict):
+		c.String(http.StatusConflict, "short link %v", err)
+	default:
+		c.String(http.StatusInternalServerError, "failed to %s: %v", action, err)
+	}
+}
+
+func redirectHandler(c *gin.Context) {
+	link, err := getLink(c.Param("s"))
+	if errors.Is(err, errNotFound) {
+		notFoundHandler(c, c.Param("s"))
+		return
+	}
+	if err != nil {
+		linkErr(c, "query", err)
+		return
+	}
+
+	// the rest of the path as sent, c.Param("rest") is already unescaped
+	_, rest, _ := strings.Cut(strings.TrimPrefix(c.Request.URL.EscapedPath(), "/"), "/")
+
+	target, err := expand(link.Long, rest, c.Request.URL.RawQuery)
+	if err != nil {
+		c.String(http.StatusBadRequest, "failed to expand go/%s: %v", link.Short, err)
+		return
+	}
+
+	logClick(link, c)
+
+	c.Redirect(http.StatusTemporaryRedirect, target)
+}
+
+func createHandler(c *gin.Context) {
+	req, ok := bindLinkReq(c)
+	if !ok {
+		return
+	}
+
+	link := req.Link()
+	link.CreatedBy = currentUser(c)
+	err := insertLink(link)
+	if err != nil {
+		linkErr(c, "insert", err)
+		return
+	}
+
+	c.Redirect(http.StatusTemporaryRedirect, req.Long)
+}
+
+// editableLink returns the link of the request if the user may edit it.
+func editableLink(c *gin.Context) (Link, bool) {
+	link, err := getLink(c.Param("s"))
+	if err != nil {
+		linkErr(c, "query", err)
+		return link, false
+	}
+
+	if !canEdit(currentUser(c), link) {
+		c.String(http.StatusForbidden, "only the owner or an admin can edit go/%s", link.Short)
+		return link, false
+	}
+
+	return link, true
+}
+
+func editPageHandler(c *gin.Context) {
+	link, ok := editableLink(c)
+	if !ok {
+		return
+	}
+
+	c.HTML(http.StatusOK, "edit.html", gin.H{
+		"link": link,
+	})
+}
+
+func updateHandler(c *gin.Context) {
+	link, ok := editableLink(c)
+	if !ok {
+		return
+	}
+
+	req, ok := bindLinkReq(c)
+	if !ok {
+		return
+	}
+
+	if err := updateLink(link.ID, req.Link()); err != nil {
+		linkErr(c, "update", err)
+		return
+	}
+
+	c.Redirect(http.StatusFound, "/")
+}
+
+func deleteHandler(c *gin.Context) {
+	link, ok := editableLink(c)
+	if !ok {
+		return
+	}
+
+	if err := deleteLink(link.ID); err != nil {
+		linkErr(c, "delete", err)
+		return
+	}
+
+	c.Redirect(http.StatusFound, "/")
+}
@@ -1,17 +1,18 @@
 package main
 
 import (
-	"bytes"
 	"database/sql"
 	"embed"
-	"encoding/json"
 	"flag"
+	"fmt"
 	"html/template"
 	"io/fs"
 	"log"
 	"net/http"
 	"os"
 	"path/filepath"
+	"strings"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	_ "modernc.org/sqlite"
@@ -35,6 +36,25 @@ var (
 	DbPath = filepath.Join(HOMEDIR, ".g.db")
 
 	needAddEtcHostItem = false
+
+	AuthMode         = "none"
+	AuthHeader       = "X-Forwarded-User"
+	UsersFile        = filepath.Join(HOMEDIR, ".golink-users")
+	Admins           = ""
+	OIDCIssuer       = ""
+	OIDCClientID     = ""
+	OIDCClientSecret = os.Getenv("GOLINK_OIDC_CLIENT_SECRET")
+	OIDCRedirectURL  = ""
+	OIDCClaim        = "email"
+	SecureCookies    = false
+
+	CheckInterval = 6 * time.Hour
+
+	Listen  = ":80"
+	TLSCert = ""
+	TLSKey  = ""
+
+	Server = os.Getenv("GOLINK_SERVER")
 )
 
 func addEtcHostItem() {
@@ -43,191 +63,150 @@ func addEtcHostItem() {
 		log.Fatalf("failed to read hosts file: %v", err)
 	}
 
-	newRow := "127.0.0.1" + " " + "go"
-	if !bytes.Contains(raw, []byte(newRow)) {
-		raw = append(raw, []byte(newRow)...)
-	}
-
-	err = os.WriteFile("/etc/hosts", raw, 0644)
-	if err != nil {
+	if err := writeHosts("/etc/hosts", addHostsEntry(raw, "127.0.0.1 go")); err != nil {
 		log.Fatalf("failed to write hosts file: %v", err)
 	}
 }
 
 func init() {
 	flag.StringVar(&DbPath, "db", DbPath, "path to sqlite db")
-	flag.BoolVar(&needAddEtcHostItem, "i", false, "add item to /etc/hosts")
-	flag.Parse()
-
-	if needAddEtcHostItem {
-		// need run as root
-		addEtcHostItem()
+	flag.BoolVar(&needAddEtcHostItem, "i", false, "add item to /etc/hosts, same as `golink hosts add`")
+	flag.StringVar(&AuthMode, "auth", AuthMode, "authentication: none, proxy, basic or oidc")
+	flag.StringVar(&AuthHeader, "auth-header", AuthHeader, "header with the user set by the reverse proxy, for -auth=proxy")
+	flag.StringVar(&UsersFile, "users", UsersFile, "htpasswd file with bcrypt hashes, for -auth=basic")
+	flag.StringVar(&Admins, "admins", Admins, "comma separated users allowed to edit all links, import and export")
+	flag.StringVar(&OIDCIssuer, "oidc-issuer", OIDCIssuer, "OpenID Connect issuer URL")
+	flag.StringVar(&OIDCClientID, "oidc-client-id", OIDCClientID, "OpenID Connect client ID")
+	flag.StringVar(&OIDCClientSecret, "oidc-client-secret", OIDCClientSecret, "OpenID Connect client secret, default $GOLINK_OIDC_CLIENT_SECRET")
+	flag.StringVar(&OIDCRedirectURL, "oidc-redirect-url", OIDCRedirectURL, "URL of /.auth/callback as seen by browsers, e.g. http://go/.auth/callback")
+	flag.StringVar(&OIDCClaim, "oidc-claim", OIDCClaim, "ID token claim used as the user name")
+	flag.BoolVar(&SecureCookies, "secure-cookies", SecureCookies, "mark login cookies Secure behind a proxy that terminates TLS, always done with -tls-cert")
+	flag.DurationVar(&CheckInterval, "check-interval", CheckInterval, "how often to check that long links work, 0 to disable")
+	flag.StringVar(&Listen, "listen", Listen, "address to listen on")
+	flag.StringVar(&TLSCert, "tls-cert", TLSCert, "TLS certificate file, serves HTTPS with -tls-key")
+	flag.StringVar(&TLSKey, "tls-key", TLSKey, "TLS private key file")
+	flag.StringVar(&Server, "server", Server, "URL of the server for the add, rm, ls, stats and export commands, they use -db directly without it, default $GOLINK_SERVER")
+	flag.Usage = func() {
+		fmt.Fprint(flag.CommandLine.Output(), "usage: golink [flags]\n       golink hosts add|remove|status\n"+cliUsage)
+		flag.PrintDefaults()
 	}
+}
 
+func openDB() {
 	var err error
 	db, err = sql.Open("sqlite", DbPath)
 	if err != nil {
 		log.Fatalf("failed to open sqlite: %v", err)
 	}
 
-	_, err = db.Exec(SQLSchema)
-	if err != nil {
-		log.Fatalf("failed to create table: %v", err)
+	db.SetMaxOpenConns(1)
+
+	if err = migrate(db); err != nil {
+		log.Fatalf("failed to migrate db: %v", err)
 	}
 }
 
 func main() {
+	flag.Parse()
+
+	switch flag.Arg(0) {
+	case "hosts":
+		runHosts(flag.Args()[1:])
+		return
+	case "add", "rm", "ls", "stats", "export":
+		runCLI(flag.Arg(0), flag.Args()[1:])
+		return
+	case "":
+	default:
+		log.Fatalf("unknown command %q", flag.Arg(0))
+	}
+
+	if (TLSCert == "") != (TLSKey == "") {
+		log.Fatal("-tls-cert and -tls-key go together")
+	}
+
+	if needAddEtcHostItem {
+		// need run as root
+		addEtcHostItem()
+	}
+
+	openDB()
 	serve()
 }
 
 type Link struct {
-	ID        int    `json:"id"`
-	Short     string `json:"short"`
-	Long      string `json:"long"`
-	Redirects int    `json:"redirects"`
+	ID          int       `json:"id"`
+	Short       string    `json:"short"`
+	Long        string    `json:"long"`
+	Description string    `json:"description,omitempty"`
+	Redirects   int       `json:"redirects"`
+	CreatedBy   string    `json:"created_by"`
+	CreatedAt   time.Time `json:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at"`
+	Aliases     []string  `json:"aliases,omitempty"`
+
+	// result of the last health check of Long
+	CheckStatus int       `json:"check_status,omitempty"`
+	CheckError  string    `json:"check_error,omitempty"`
+	CheckedAt   time.Time `json:"checked_at"`
 }
 
 func serve() {
 	gin.SetMode(gin.ReleaseMode)
 	r := gin.Default()
 
-	tmpl := template.Must(template.New("").ParseFS(FS, "tmpl/*.html"))
+	tmpl := template.Must(template.New("").Funcs(template.FuncMap{
+		"join": strings.Join,
+	}).ParseFS(FS, "tmpl/*.html"))
 	r.SetHTMLTemplate(tmpl)
 
 	fe, _ := fs.Sub(FS, "static")
 	r.StaticFS("/static", http.FS(fe))
 
-	r.GET("/", func(c *gin.Context) {
-		var links []Link
-		rows, err := db.Query("SELECT * FROM links ORDER BY redirects DESC")
-		if err != nil {
-			c.String(http.StatusInternalServerError, "failed to query: %v", err)
-			return
-		}
-
-		for rows.Next() {
-			var link Link
-			err = rows.Scan(&link.ID, &link.Short, &link.Long, &link.Redirects)
-			if err != nil {
-				c.String(500, "failed to scan: %v", err)
-				return
-			}
-
-			links = append(links, link)
-		}
-
-		c.HTML(http.StatusOK, "index.html", gin.H{
-			"links": links,
-		})
-	})
-
-	r.GET("/:s", func(c *gin.Context) {
-		s := c.Param("s")
-
-		var link Link
-		rows, err := db.Query("SELECT * FROM links WHERE short = ?", s)
-		if err != nil {
-			c.String(http.StatusInternalServerError, "failed to query: %v", err)
-			return
-		}
-		defer rows.Close()
-
-		for rows.Next() {
-			err = rows.Scan(&link.ID, &link.Short, &link.Long, &link.Redirects)
-			if err != nil {
-				c.String(http.StatusInternalServerError, "failed to scan: %v", err)
-				return
-			}
-		}
-
-		if link.Short == "" {
-			c.String(http.StatusNotFound, "not found")
-			return
-		}
-
-		go func() {
-			link.Redirects++
-			_, _ = db.Exec("UPDATE links SET redirects = ? WHERE id = ?", link.Redirects, link.ID)
-		}()
-
-		c.Redirect(http.StatusTemporaryRedirect, link.Long)
-	})
-
-	type LinkReq struct {
-		Short string `form:"short" binding:"required"`
-		Long  string `form:"long" binding:"required"`
+	auth, err := newAuthenticator()
+	if err != nil {
+		log.Fatalf("failed to set up %s auth: %v", AuthMode, err)
+	}
+	if oa, ok := auth.(*oidcAuth); ok {
+		r.GET("/.auth/callback", oa.callbackHandler)
+	}
+	r.GET("/.auth/logout", logoutHandler)
+	r.GET("/.opensearch.xml", openSearchHandler)
+
+	// routes below need a user
+	r.Use(authMiddleware(auth))
+
+	r.GET("/", indexHandler)
+	r.GET("/:s", redirectHandler)
+	r.GET("/:s/*rest", redirectHandler)
+	r.POST("/", createHandler)
+
+	r.GET("/.edit/:s", editPageHandler)
+	r.POST("/.edit/:s", updateHandler)
+	r.POST("/.delete/:s", deleteHandler)
+	r.GET("/.stats/:s", statsHandler)
+	r.GET("/.stale", staleHandler)
+	r.GET("/.search", searchHandler)
+	r.GET("/.broken", brokenHandler)
+
+	r.GET("/.export", requireAdmin, exportHandler)
+	r.POST("/.export", requireAdmin, exportHandler)
+	r.POST("/.import", requireAdmin, importHandler)
+
+	r.GET("/.api/links", apiListHandler)
+	r.POST("/.api/links", apiCreateHandler)
+	r.GET("/.api/links/:s", apiGetHandler)
+	r.DELETE("/.api/links/:s", apiDeleteHandler)
+	r.GET("/.api/links/:s/stats", apiStatsHandler)
+
+	if CheckInterval > 0 {
+		go checkLinks(CheckInterval)
 	}
 
-	r.POST("/", func(c *gin.Context) {
-		var req LinkReq
-		if err := c.ShouldBind(&req); err != nil {
-			c.String(http.StatusBadRequest, "missing short or long link")
-			return
-		}
-
-		if len(req.Short) > 32 {
-			c.String(http.StatusBadRequest, "short link too long")
-			return
-		}
-
-		if len(req.Long) > 500 {
-			c.String(http.StatusBadRequest, "long link too long")
-			return
-		}
-
-		_, err := db.Exec("INSERT INTO links (short, long) VALUES (?, ?)", req.Short, req.Long)
-		if err != nil {
-			c.String(http.StatusInternalServerError, "failed to insert: %v", err)
-			return
-		}
-
-		c.Redirect(http.StatusTemporaryRedirect, req.Long)
-	})
-
-	r.POST("/.export", func(c *gin.Context) {
-		var links []Link
-		rows, err := db.Query("SELECT * FROM links")
-		if err != nil {
-			c.String(http.StatusInternalServerError, "failed to query: %v", err)
-			return
-		}
-
-		for rows.Next() {
-			var link Link
-			err = rows.Scan(&link.ID, &link.Short, &link.Long, &link.Redirects)
-			if err != nil {
-				c.String(500, "failed to scan: %v", err)
-				return
-			}
-
-			links = append(links, link)
-		}
-
-		jsonData, err := json.Marshal(links)
-		if err != nil {
-			c.String(http.StatusInternalServerError, "failed to marshal: %v", err)
-		}
-		c.Data(http.StatusOK, "application/json", jsonData)
-	})
-
-	r.POST("/.import", func(c *gin.Context) {
-		var links []Link
-		err := c.ShouldBind(&links)
-		if err != nil {
-			c.String(http.StatusBadRequest, "failed to bind: %v", err)
-			return
-		}
-
-		for _, link := range links {
-			_, err := db.Exec("INSERT INTO links (id, short, long, redirects) VALUES (?, ?, ?, ?)", link.ID, link.Short, link.Long, link.Redirects)
-			if err != nil {
-				c.String(http.StatusInternalServerError, "failed to insert: %v", err)
-				return
-			}
-		}
-
-		c.String(http.StatusOK, "ok")
-	})
-
-	r.Run(":80")
+	if TLSCert != "" {
+		err = r.RunTLS(Listen, TLSCert, TLSKey)
+	} else {
+		err = r.Run(Listen)
+	}
+	log.Fatalf("failed to serve: %v", err)
 }
@@ -0,0 +1,207 @@
+package main
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	pageSize       = 50
+	maxSuggestions = 10
+	maxDistance    = 2
+)
+
+// searchLinks returns a page of the links whose short name, long URL or
+// description contain q, most used first, and the number of matches.
+func searchLinks(q string, page int) ([]Link, int, error) {
+	where, args := "", []any{}
+	if q != "" {
+		pattern := "%" + likeEscaper.Replace(q) + "%"
+		where = ` WHERE short LIKE ?1 ESCAPE '\' OR long LIKE ?1 ESCAPE '\' OR description LIKE ?1 ESCAPE '\'`
+		args = append(args, pattern)
+	}
+
+	var total int
+	if err := db.QueryRow("SELECT COUNT(*) FROM links"+where, args...).Scan(&total); err != nil {
+		return nil, 0, err
+	}
+
+	links, err := queryLinks("SELECT "+linkColumns+" FROM links"+where+" ORDER BY redirects DESC, short LIMIT ? OFFSET ?",
+		append(args, pageSize, (page-1)*pageSize)...)
+	return links, total, err
+}
+
+var likeEscaper = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+
+// suggest returns the links whose short name is close to short: within a
+// small edit distance, or one a prefix of the other.
+func suggest(short string) ([]Link, error) {
+	links, err := queryLinks("SELECT " + linkColumns + " FROM links")
+	if err != nil {
+		return nil, err
+	}
+
+	short = strings.ToLower(short)
+	type scored struct {
+		link  Link
+		score int
+	}
+	var matches []scored
+	for _, link := range links {
+		name := strings.ToLower(link.Short)
+
+		score := levenshtein(short, name)
+		if strings.HasPrefix(name, short) || strings.HasPrefix(short, name) {
+			// a prefix is as good as a typo
+			score = min(score, 1)
+		}
+		if score <= maxDistance {
+			matches = append(matches, scored{link, score})
+		}
+	}
+
+	sort.Slice(matches, func(i, j int) bool {
+		if matches[i].score != matches[j].score {
+			return matches[i].score < matches[j].score
+		}
+		return matches[i].link.Redirects > matches[j].link.Redirects
+	})
+
+	var result []Link
+	for i := 0; i < len(matches) && i < maxSuggestions; i++ {
+		result = append(result, matches[i].link)
+	}
+	return result, nil
+}
+
+// levenshtein returns the edit distance of a and b.
+func levenshtein(a, b string) int {
+	ra, rb := []rune(a), []rune(b)
+
+	prev := make([]int, len(rb)+1)
+	cur := make([]int, len(rb)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+
+	for i := 1; i <= len(ra); i++ {
+		cur[0] = i
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev, cur = cur, prev
+	}
+
+	return prev[len(rb)]
+}
+
+func min(v int, vs ...int) int {
+	for _, x := range vs {
+		if x < v {
+			v = x
+		}
+	}
+	return v
+}
+
+func indexHandler(c *gin.Context) {
+	q := strings.TrimSpace(c.Query("q"))
+	page, err := strconv.Atoi(c.DefaultQuery("page", "1"))
+	if err != nil || page < 1 {
+		page = 1
+	}
+
+	links, total, err := searchLinks(q, page)
+	if err != nil {
+		c.String(http.StatusInternalServerError, "failed to query: %v", err)
+		return
+	}
+
+	pages := (total + pageSize - 1) / pageSize
+	user := currentUser(c)
+	c.HTML(http.StatusOK, "index.html", gin.H{
+		"links": links,
+		"user":  user,
+		"admin": isAdmin(user),
+		"q":     q,
+		"total": total,
+		"page":  page,
+		"pages": pages,
+		"prev":  page - 1,
+		"next":  page + 1,
+	})
+}
+
+// notFoundHandler helps when go/short does not exist: it suggests similar
+// links and offers to create it.
+func notFoundHandler(c *gin.Context, short string) {
+	suggestions, err := suggest(short)
+	if err != nil {
+		c.String(http.StatusInternalServerError, "failed to query: %v", err)
+		return
+	}
+
+	c.HTML(http.StatusNotFound, "notfound.html", gin.H{
+		"short":       short,
+		"suggestions": suggestions,
+	})
+}
+
+// searchHandler resolves what was typed after the go keyword in the
+// browser's address bar, "gh abcdlsj/share" goes to go/gh/abcdlsj/share.
+func searchHandler(c *gin.Context) {
+	q := strings.TrimPrefix(strings.TrimSpace(c.Query("q")), "go/")
+	if q == "" {
+		c.Redirect(http.StatusFound, "/")
+		return
+	}
+
+	path := q
+	if short, rest, ok := strings.Cut(q, " "); ok {
+		path = short + "/" + strings.TrimSpace(rest)
+	}
+
+	segments := strings.Split(path, "/")
+	for i, s := range segments {
+		segments[i] = url.PathEscape(s)
+	}
+
+	c.Redirect(http.StatusFound, "/"+strings.Join(segments, "/"))
+}
+
+const openSearchXML = `<?xml version="1.0" encoding="UTF-8"?>
+<OpenSearchDescription xmlns="http://a9.com/-/spec/opensearch/1.1/" xmlns:moz="http://www.mozilla.org/2006/browser/search/">
+	<ShortName>go</ShortName>
+	<Description>Go links</Description>
+	<InputEncoding>UTF-8</InputEncoding>
+	<Image width="16" height="16" type="image/x-icon">%[1]s/static/favicon.ico</Image>
+	<Url type="text/html" method="get" template="%[1]s/.search?q={searchTerms}"/>
+	<moz:SearchForm>%[1]s/</moz:SearchForm>
+</OpenSearchDescription>
+`
+
+// openSearchHandler serves the OpenSearch description, which lets browsers
+// add golink as a search engine with the "go" keyword.
+func openSearchHandler(c *gin.Context) {
+	scheme := "http"
+	if c.Request.TLS != nil || c.GetHeader("X-Forwarded-Proto") == "https" {
+		scheme = "https"
+	}
+
+	var base bytes.Buffer
+	xml.EscapeText(&base, []byte(scheme+"://"+c.Request.Host))
+
+	c.Data(http.StatusOK, "application/opensearchdescription+xml", []byte(fmt.Sprintf(openSearchXML, base.String())))
+}
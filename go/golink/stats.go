@@ -0,0 +1,236 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const maxClickField = 500
+
+// recordClick stores a click and bumps the redirects counter of the link in
+// a single transaction, so concurrent redirects don't lose counts.
+func recordClick(id int, referrer, userAgent string) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec("UPDATE links SET redirects = redirects + 1 WHERE id = ?", id); err != nil {
+		return err
+	}
+
+	_, err = tx.Exec("INSERT INTO clicks (link, ts, referrer, user_agent) VALUES (?, ?, ?, ?)",
+		id, time.Now().Unix(), truncate(referrer, maxClickField), truncate(userAgent, maxClickField))
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func truncate(s string, n int) string {
+	if len(s) > n {
+		return s[:n]
+	}
+	return s
+}
+
+type DayClicks struct {
+	Date   string `json:"date"`
+	Clicks int    `json:"clicks"`
+}
+
+type Referrer struct {
+	Referrer string `json:"referrer"`
+	Clicks   int    `json:"clicks"`
+}
+
+// Stats are the clicks of a link in the last Days days.
+type Stats struct {
+	Link      Link        `json:"link"`
+	Days      int         `json:"days"`
+	Total     int         `json:"total"`
+	Daily     []DayClicks `json:"daily"`
+	Referrers []Referrer  `json:"referrers"`
+}
+
+func linkStats(link Link, days int) (Stats, error) {
+	stats := Stats{Link: link, Days: days}
+
+	var err error
+	stats.Daily, err = dailyClicks(link.ID, days)
+	if err != nil {
+		return stats, fmt.Errorf("failed to query clicks: %w", err)
+	}
+	for _, d := range stats.Daily {
+		stats.Total += d.Clicks
+	}
+
+	stats.Referrers, err = topReferrers(link.ID, time.Now().AddDate(0, 0, -days), 10)
+	if err != nil {
+		return stats, fmt.Errorf("failed to query referrers: %w", err)
+	}
+
+	return stats, nil
+}
+
+// dailyClicks returns the clicks of the last days, oldest first, including
+// days without clicks.
+func dailyClicks(id int, days int) ([]DayClicks, error) {
+	now := time.Now()
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.Local)
+	from := today.AddDate(0, 0, -(days - 1))
+
+	rows, err := db.Query(`SELECT date(ts, 'unixepoch', 'localtime') AS day, COUNT(*) FROM clicks
+		WHERE link = ? AND ts >= ? GROUP BY day`, id, from.Unix())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	counts := make(map[string]int)
+	for rows.Next() {
+		var (
+			day    string
+			clicks int
+		)
+		if err := rows.Scan(&day, &clicks); err != nil {
+			return nil, err
+		}
+		counts[day] = clicks
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	result := make([]DayClicks, days)
+	for i := range result {
+		day := from.AddDate(0, 0, i).Format("2006-01-02")
+		result[i] = DayClicks{Date: day, Clicks: counts[day]}
+	}
+
+	return result, nil
+}
+
+func topReferrers(id int, since time.Time, limit int) ([]Referrer, error) {
+	rows, err := db.Query(`SELECT referrer, COUNT(*) AS n FROM clicks
+		WHERE link = ? AND ts >= ? AND referrer != '' GROUP BY referrer ORDER BY n DESC LIMIT ?`,
+		id, since.Unix(), limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var refs []Referrer
+	for rows.Next() {
+		var r Referrer
+		if err := rows.Scan(&r.Referrer, &r.Clicks); err != nil {
+			return nil, err
+		}
+		refs = append(refs, r)
+	}
+
+	return refs, rows.Err()
+}
+
+// StaleLink is a link without clicks in a while, LastClick is zero if it was
+// never clicked since clicks are recorded.
+type StaleLink struct {
+	Link
+	LastClick time.Time
+}
+
+// staleLinks returns the links not clicked since before, least recently
+// used first. Links created after before are not stale yet.
+func staleLinks(before time.Time) ([]StaleLink, error) {
+	rows, err := db.Query(`SELECT `+linkColumns+`, (SELECT MAX(ts) FROM clicks WHERE link = links.id) AS last
+		FROM links WHERE IFNULL(last, 0) < ?1 AND created_at < ?1 ORDER BY IFNULL(last, 0), short`, before.Unix())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var links []StaleLink
+	for rows.Next() {
+		var (
+			sl   StaleLink
+			last sql.NullInt64
+			err  error
+		)
+		if sl.Link, err = scanLink(rows, &last); err != nil {
+			return nil, err
+		}
+		if last.Valid {
+			sl.LastClick = time.Unix(last.Int64, 0)
+		}
+		links = append(links, sl)
+	}
+
+	return links, rows.Err()
+}
+
+// days reads a number of days from the query, bounded to a sane range.
+func days(c *gin.Context, def int) int {
+	n, err := strconv.Atoi(c.DefaultQuery("days", strconv.Itoa(def)))
+	if err != nil || n < 1 {
+		return def
+	}
+	if n > 3650 {
+		return 3650
+	}
+	return n
+}
+
+func statsHandler(c *gin.Context) {
+	link, err := getLink(c.Param("s"))
+	if err != nil {
+		linkErr(c, "query", err)
+		return
+	}
+
+	stats, err := linkStats(link, days(c, 30))
+	if err != nil {
+		c.String(http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	c.HTML(http.StatusOK, "stats.html", gin.H{
+		"link":      link,
+		"days":      stats.Days,
+		"daily":     stats.Daily,
+		"total":     stats.Total,
+		"referrers": stats.Referrers,
+	})
+}
+
+func staleHandler(c *gin.Context) {
+	n := days(c, 90)
+
+	links, err := staleLinks(time.Now().AddDate(0, 0, -n))
+	if err != nil {
+		c.String(http.StatusInternalServerError, "failed to query: %v", err)
+		return
+	}
+
+	c.HTML(http.StatusOK, "stale.html", gin.H{
+		"days":  n,
+		"links": links,
+	})
+}
+
+func logClick(link Link, c *gin.Context) {
+	referrer, userAgent := c.Request.Referer(), c.Request.UserAgent()
+
+	go func() {
+		if err := recordClick(link.ID, referrer, userAgent); err != nil {
+			log.Printf("failed to record click of %s: %v", link.Short, err)
+		}
+	}()
+}
@@ -0,0 +1,441 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html"
+	"io"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	nethtml "golang.org/x/net/html"
+)
+
+// Links are exported and imported as:
+//
+//   - json: our own array of links
+//   - csv: with a header row, only short and long are required on import
+//   - html: Netscape bookmarks, the short name is the keyword (SHORTCUTURL)
+//   - tailscale: JSON lines as exported by tailscale/golink
+var formats = []string{"json", "csv", "html", "tailscale"}
+
+var csvHeader = []string{"short", "long", "description", "aliases", "created_by", "created_at", "redirects"}
+
+func encodeLinks(w io.Writer, format string, links []Link) error {
+	switch format {
+	case "json":
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(links)
+	case "csv":
+		cw := csv.NewWriter(w)
+		cw.Write(csvHeader)
+		for _, l := range links {
+			cw.Write([]string{l.Short, l.Long, l.Description, strings.Join(l.Aliases, " "), l.CreatedBy,
+				l.CreatedAt.Format(time.RFC3339), strconv.Itoa(l.Redirects)})
+		}
+		cw.Flush()
+		return cw.Error()
+	case "html":
+		fmt.Fprint(w, "<!DOCTYPE NETSCAPE-Bookmark-file-1>\n"+
+			"<META HTTP-EQUIV=\"Content-Type\" CONTENT=\"text/html; charset=UTF-8\">\n"+
+			"<TITLE>Go Links</TITLE>\n<H1>Go Links</H1>\n<DL><p>\n")
+		for _, l := range links {
+			fmt.Fprintf(w, "    <DT><A HREF=\"%s\" ADD_DATE=\"%d\" SHORTCUTURL=\"%s\">go/%s</A>\n",
+				html.EscapeString(l.Long), unix(l.CreatedAt), html.EscapeString(l.Short), html.EscapeString(l.Short))
+			if l.Description != "" {
+				fmt.Fprintf(w, "    <DD>%s\n", html.EscapeString(l.Description))
+			}
+		}
+		_, err := fmt.Fprint(w, "</DL><p>\n")
+		return err
+	case "tailscale":
+		enc := json.NewEncoder(w)
+		for _, l := range links {
+			err := enc.Encode(tailscaleLink{
+				Short:    l.Short,
+				Long:     l.Long,
+				Created:  l.CreatedAt,
+				LastEdit: l.UpdatedAt,
+				Owner:    l.CreatedBy,
+			})
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	default:
+		return fmt.Errorf("unknown format %q", format)
+	}
+}
+
+type tailscaleLink struct {
+	Short    string
+	Long     string
+	Created  time.Time
+	LastEdit time.Time
+	Owner    string
+}
+
+func decodeLinks(r io.Reader, format string) ([]Link, error) {
+	switch format {
+	case "json":
+		var links []Link
+		err := json.NewDecoder(r).Decode(&links)
+		return links, err
+	case "csv":
+		return decodeCSV(r)
+	case "html":
+		return decodeBookmarks(r)
+	case "tailscale":
+		var links []Link
+		dec := json.NewDecoder(r)
+		for {
+			var tl tailscaleLink
+			err := dec.Decode(&tl)
+			if errors.Is(err, io.EOF) {
+				return links, nil
+			}
+			if err != nil {
+				return nil, err
+			}
+			links = append(links, Link{
+				Short:     tl.Short,
+				Long:      tl.Long,
+				CreatedAt: tl.Created,
+				UpdatedAt: tl.LastEdit,
+				CreatedBy: tl.Owner,
+			})
+		}
+	default:
+		return nil, fmt.Errorf("unknown format %q", format)
+	}
+}
+
+func decodeCSV(r io.Reader) ([]Link, error) {
+	records, err := csv.NewReader(r).ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	if len(records) == 0 {
+		return nil, nil
+	}
+
+	col := make(map[string]int)
+	for i, name := range records[0] {
+		col[strings.ToLower(strings.TrimSpace(name))] = i
+	}
+	if _, ok := col["short"]; !ok {
+		return nil, errors.New("csv needs a header with short and long columns")
+	}
+	if _, ok := col["long"]; !ok {
+		return nil, errors.New("csv needs a header with short and long columns")
+	}
+
+	field := func(record []string, name string) string {
+		if i, ok := col[name]; ok && i < len(record) {
+			return strings.TrimSpace(record[i])
+		}
+		return ""
+	}
+
+	var links []Link
+	for _, record := range records[1:] {
+		link := Link{
+			Short:       field(record, "short"),
+			Long:        field(record, "long"),
+			Description: field(record, "description"),
+			Aliases:     strings.Fields(field(record, "aliases")),
+			CreatedBy:   field(record, "created_by"),
+		}
+		if t, err := time.Parse(time.RFC3339, field(record, "created_at")); err == nil {
+			link.CreatedAt = t
+		}
+		link.Redirects, _ = strconv.Atoi(field(record, "redirects"))
+		links = append(links, link)
+	}
+
+	return links, nil
+}
+
+// decodeBookmarks reads the links of a Netscape bookmarks file. Bookmarks
+// without a keyword get a short name made from their title.
+func decodeBookmarks(r io.Reader) ([]Link, error) {
+	z := nethtml.NewTokenizer(r)
+
+	var (
+		links  []Link
+		cur    *Link
+		inA    bool
+		inDD   bool
+		title  strings.Builder
+		hasKey bool
+	)
+	for {
+		tt := z.Next()
+		switch tt {
+		case nethtml.ErrorToken:
+			if errors.Is(z.Err(), io.EOF) {
+				return links, nil
+			}
+			return nil, z.Err()
+		case nethtml.StartTagToken:
+			tok := z.Token()
+			switch tok.Data {
+			case "a":
+				links = append(links, Link{})
+				cur = &links[len(links)-1]
+				inA, inDD, hasKey = true, false, false
+				title.Reset()
+				for _, attr := range tok.Attr {
+					switch strings.ToLower(attr.Key) {
+					case "href":
+						cur.Long = attr.Val
+					case "shortcuturl":
+						cur.Short = strings.TrimPrefix(attr.Val, "go/")
+						hasKey = cur.Short != ""
+					case "add_date":
+						if ts, err := strconv.ParseInt(attr.Val, 10, 64); err == nil && ts > 0 {
+							cur.CreatedAt = time.Unix(ts, 0)
+						}
+					}
+				}
+			case "dd":
+				inDD = cur != nil
+			case "dt", "dl", "h3":
+				inDD = false
+			}
+		case nethtml.EndTagToken:
+			if z.Token().Data == "a" && cur != nil {
+				inA = false
+				if !hasKey {
+					cur.Short = slug(strings.TrimPrefix(strings.TrimSpace(title.String()), "go/"))
+				}
+			}
+		case nethtml.TextToken:
+			text := string(z.Text())
+			switch {
+			case inA:
+				title.WriteString(text)
+			case inDD:
+				cur.Description += strings.TrimSpace(text)
+			}
+		}
+	}
+}
+
+var slugRe = regexp.MustCompile(`[^a-z0-9_-]+`)
+
+func slug(title string) string {
+	s := strings.Trim(slugRe.ReplaceAllString(strings.ToLower(title), "-"), "-")
+	if len(s) > 32 {
+		s = strings.TrimRight(s[:32], "-")
+	}
+	return s
+}
+
+// Change is what an import does to one link.
+type Change struct {
+	Short   string `json:"short"`
+	Action  string `json:"action"` // create, update, skip or unchanged
+	Long    string `json:"long"`
+	OldLong string `json:"old_long,omitempty"`
+}
+
+type ImportResult struct {
+	DryRun  bool     `json:"dry_run"`
+	Changes []Change `json:"changes"`
+}
+
+var strategies = []string{"skip", "merge", "overwrite"}
+
+// importLinks applies links with the given strategy in one transaction:
+//
+//   - skip: existing links are left alone
+//   - merge: existing links get the imported long link, the description if
+//     they had none, and the imported aliases next to theirs
+//   - overwrite: existing links are replaced by the imported ones, owner
+//     included
+//
+// Links without an owner are owned by user. With dryRun the transaction is
+// rolled back, the changes are the diff.
+func importLinks(links []Link, user, strategy string, dryRun bool) (ImportResult, error) {
+	result := ImportResult{DryRun: dryRun, Changes: []Change{}}
+	if !contains(strategies, strategy) {
+		return result, fmt.Errorf("unknown strategy %q", strategy)
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return result, err
+	}
+	defer tx.Rollback()
+
+	seen := make(map[string]bool)
+	for _, link := range links {
+		req := LinkReq{Short: link.Short, Long: link.Long, Description: link.Description, Aliases: strings.Join(link.Aliases, ",")}
+		if link.Short == "" || link.Long == "" {
+			return result, fmt.Errorf("link %q: missing short or long link", link.Short)
+		}
+		if err := req.validate(); err != nil {
+			return result, fmt.Errorf("link %q: %w", link.Short, err)
+		}
+		if seen[link.Short] {
+			return result, fmt.Errorf("link %q: imported twice", link.Short)
+		}
+		seen[link.Short] = true
+		link.Aliases = req.aliases()
+		if link.CreatedBy == "" {
+			link.CreatedBy = user
+		}
+
+		old, err := scanLink(tx.QueryRow("SELECT "+linkColumns+" FROM links WHERE short = ?", link.Short))
+		if errors.Is(err, sql.ErrNoRows) {
+			if err := insertLinkTx(tx, link); err != nil {
+				return result, err
+			}
+			result.Changes = append(result.Changes, Change{Short: link.Short, Action: "create", Long: link.Long})
+			continue
+		}
+		if err != nil {
+			return result, err
+		}
+
+		change := Change{Short: link.Short, Long: link.Long, OldLong: old.Long}
+
+		updated := link
+		switch strategy {
+		case "skip":
+			change.Action = "skip"
+			result.Changes = append(result.Changes, change)
+			continue
+		case "merge":
+			updated.Description = or(old.Description, link.Description)
+			updated.Aliases = old.Aliases
+			for _, alias := range link.Aliases {
+				if !contains(updated.Aliases, alias) {
+					updated.Aliases = append(updated.Aliases, alias)
+				}
+			}
+		case "overwrite":
+			if _, err := tx.Exec("UPDATE links SET created_by = ? WHERE id = ?", link.CreatedBy, old.ID); err != nil {
+				return result, err
+			}
+		default:
+			return result, fmt.Errorf("unknown strategy %q", strategy)
+		}
+
+		if sameLink(old, updated) && (strategy != "overwrite" || old.CreatedBy == link.CreatedBy) {
+			change.Action = "unchanged"
+			result.Changes = append(result.Changes, change)
+			continue
+		}
+
+		if err := updateLinkTx(tx, old.ID, updated); err != nil {
+			return result, err
+		}
+		change.Action = "update"
+		result.Changes = append(result.Changes, change)
+	}
+
+	if dryRun {
+		return result, nil
+	}
+	return result, tx.Commit()
+}
+
+func sameLink(a, b Link) bool {
+	if a.Long != b.Long || a.Description != b.Description || len(a.Aliases) != len(b.Aliases) {
+		return false
+	}
+	for _, alias := range b.Aliases {
+		if !contains(a.Aliases, alias) {
+			return false
+		}
+	}
+	return true
+}
+
+func or(s, def string) string {
+	if s != "" {
+		return s
+	}
+	return def
+}
+
+var exportTypes = map[string]string{
+	"json":      "application/json",
+	"csv":       "text/csv",
+	"html":      "text/html",
+	"tailscale": "application/x-ndjson",
+}
+
+var exportExts = map[string]string{
+	"json":      "json",
+	"csv":       "csv",
+	"html":      "html",
+	"tailscale": "jsonl",
+}
+
+func exportHandler(c *gin.Context) {
+	format := c.DefaultQuery("format", "json")
+	if !contains(formats, format) {
+		c.String(http.StatusBadRequest, "format must be one of %s", strings.Join(formats, ", "))
+		return
+	}
+
+	links, err := queryLinks("SELECT " + linkColumns + " FROM links ORDER BY short")
+	if err != nil {
+		c.String(http.StatusInternalServerError, "failed to query: %v", err)
+		return
+	}
+	if links == nil {
+		links = []Link{}
+	}
+
+	c.Header("Content-Type", exportTypes[format])
+	c.Header("Content-Disposition", "attachment; filename=golinks."+exportExts[format])
+	if err := encodeLinks(c.Writer, format, links); err != nil {
+		c.String(http.StatusInternalServerError, "failed to export: %v", err)
+	}
+}
+
+func importHandler(c *gin.Context) {
+	format := c.DefaultQuery("format", "json")
+	if !contains(formats, format) {
+		c.String(http.StatusBadRequest, "format must be one of %s", strings.Join(formats, ", "))
+		return
+	}
+
+	strategy := c.DefaultQuery("strategy", "skip")
+	if !contains(strategies, strategy) {
+		c.String(http.StatusBadRequest, "strategy must be one of %s", strings.Join(strategies, ", "))
+		return
+	}
+	dryRun, _ := strconv.ParseBool(c.Query("dry_run"))
+
+	links, err := decodeLinks(c.Request.Body, format)
+	if err != nil {
+		c.String(http.StatusBadRequest, "failed to parse %s: %v", format, err)
+		return
+	}
+
+	result, err := importLinks(links, currentUser(c), strategy, dryRun)
+	if errors.Is(err, errConflict) {
+		c.String(http.StatusConflict, "short link %v, nothing imported", err)
+		return
+	}
+	if err != nil {
+		c.String(http.StatusBadRequest, "failed to import: %v, nothing imported", err)
+		return
+	}
+
+	c.JSON(http.StatusOK, result)
+}
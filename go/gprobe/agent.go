@@ -0,0 +1,289 @@
+package main
+
+import (
+	"bytes"
+	"crypto/subtle"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Agents run the same probes from other locations: `gprobe agent` registers
+// with the server, polls the probes assigned to its location and reports
+// results back over the /api/agent endpoints, authenticated with the shared
+// AGENT_TOKEN.
+
+const (
+	agentPollInterval   = 30 * time.Second
+	agentReportInterval = 5 * time.Second
+	agentMaxPending     = 10000
+)
+
+type agentResult struct {
+	Probe  string
+	Result Result
+}
+
+type agentReport struct {
+	Location string
+	Results  []agentResult
+}
+
+// Agent is a location that registered or reported to the server.
+type Agent struct {
+	Location string
+	Seen     time.Time
+}
+
+type agentRegistry struct {
+	mu   sync.Mutex
+	seen map[string]time.Time
+}
+
+var agents = &agentRegistry{
+	seen: make(map[string]time.Time),
+}
+
+func (ar *agentRegistry) touch(location string) {
+	ar.mu.Lock()
+	defer ar.mu.Unlock()
+
+	ar.seen[location] = time.Now()
+}
+
+func (ar *agentRegistry) List() []Agent {
+	ar.mu.Lock()
+	defer ar.mu.Unlock()
+
+	list := make([]Agent, 0, len(ar.seen))
+	for loc, seen := range ar.seen {
+		list = append(list, Agent{Location: loc, Seen: seen})
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Location < list[j].Location
+	})
+	return list
+}
+
+func agentAuth(c *gin.Context) {
+	if AGENT_TOKEN == "" {
+		c.AbortWithStatusJSON(404, gin.H{"error": "agents are disabled, set AGENT_TOKEN"})
+		return
+	}
+
+	token := strings.TrimPrefix(c.GetHeader("Authorization"), "Bearer ")
+	if subtle.ConstantTimeCompare([]byte(token), []byte(AGENT_TOKEN)) != 1 {
+		c.AbortWithStatusJSON(401, gin.H{"error": "invalid agent token"})
+		return
+	}
+
+	c.Next()
+}
+
+func validLocation(location string) bool {
+	return location != "" && location != LOCATION && specNameRe.MatchString(location)
+}
+
+func agentRegisterHandler(c *gin.Context) {
+	var req struct {
+		Location string
+	}
+	if err := c.ShouldBindJSON(&req); err != nil || !validLocation(req.Location) {
+		c.JSON(400, gin.H{"error": "invalid location"})
+		return
+	}
+
+	agents.touch(req.Location)
+	log.Printf("Agent %s registered from %s", req.Location, c.ClientIP())
+
+	c.JSON(200, gin.H{"location": req.Location})
+}
+
+func agentProbesHandler(c *gin.Context) {
+	location := c.Query("location")
+	if !validLocation(location) {
+		c.JSON(400, gin.H{"error": "invalid location"})
+		return
+	}
+	agents.touch(location)
+
+	probes := []*Probe{}
+	for _, p := range gpm.GetProbes() {
+		if !p.Archived && p.RunsAt(location) {
+			probes = append(probes, p)
+		}
+	}
+
+	c.JSON(200, probes)
+}
+
+func agentResultsHandler(c *gin.Context) {
+	var report agentReport
+	if err := c.ShouldBindJSON(&report); err != nil || !validLocation(report.Location) {
+		c.JSON(400, gin.H{"error": "invalid report"})
+		return
+	}
+	agents.touch(report.Location)
+
+	for _, ar := range report.Results {
+		p := gpm.GetProbe(ar.Probe)
+		if p == nil || !p.RunsAt(report.Location) {
+			continue
+		}
+		recordResult(p, report.Location, ar.Result)
+	}
+
+	c.Status(204)
+}
+
+// agentClient is the agent side: it keeps gpm in sync with the assignments
+// and buffers results until they are reported.
+type agentClient struct {
+	server string
+	token  string
+
+	mu      sync.Mutex
+	pending []agentResult
+}
+
+func runAgent(args []string) {
+	fs := flag.NewFlagSet("agent", flag.ExitOnError)
+	server := fs.String("server", os.Getenv("GPROBE_SERVER"), "URL of the gprobe server")
+	location := fs.String("location", os.Getenv("LOCATION"), "name of this location")
+	token := fs.String("token", AGENT_TOKEN, "agent token of the server, default $AGENT_TOKEN")
+	fs.Parse(args)
+
+	if *server == "" || *location == "" || *token == "" {
+		fs.Usage()
+		os.Exit(1)
+	}
+
+	LOCATION = *location
+	ac := &agentClient{
+		server: strings.TrimSuffix(*server, "/"),
+		token:  *token,
+	}
+	saveResult = ac.enqueue
+
+	for {
+		err := ac.call("POST", "/api/agent/register", map[string]string{"Location": LOCATION}, nil)
+		if err == nil {
+			break
+		}
+		log.Printf("Error registering with %s: %s, retrying", ac.server, err)
+		time.Sleep(10 * time.Second)
+	}
+	log.Printf("Registered with %s as %s", ac.server, LOCATION)
+
+	workers, err := strconv.Atoi(WORKERS)
+	if err != nil || workers <= 0 {
+		workers = 16
+	}
+
+	ac.poll()
+	NewScheduler(workers).Start()
+
+	go func() {
+		for range time.Tick(agentPollInterval) {
+			ac.poll()
+		}
+	}()
+
+	for range time.Tick(agentReportInterval) {
+		ac.report()
+	}
+}
+
+func (ac *agentClient) call(method, path string, body, out any) error {
+	var data []byte
+	if body != nil {
+		var err error
+		if data, err = json.Marshal(body); err != nil {
+			return err
+		}
+	}
+
+	req, err := http.NewRequest(method, ac.server+path, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+ac.token)
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := notifyClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("unexpected status %s", resp.Status)
+	}
+
+	if out != nil {
+		return json.NewDecoder(resp.Body).Decode(out)
+	}
+	return nil
+}
+
+func (ac *agentClient) poll() {
+	var probes []*Probe
+	if err := ac.call("GET", "/api/agent/probes?location="+LOCATION, nil, &probes); err != nil {
+		log.Printf("Error fetching probes: %s", err)
+		return
+	}
+
+	assigned := make(map[string]*Probe, len(probes))
+	for _, p := range probes {
+		assigned[p.Uid] = p
+	}
+	gpm.SetProbes(assigned)
+}
+
+func (ac *agentClient) enqueue(p *Probe, _ string, r Result) {
+	p.setLast(r)
+
+	ac.mu.Lock()
+	defer ac.mu.Unlock()
+
+	ac.pending = append(ac.pending, agentResult{Probe: p.Uid, Result: r})
+	if over := len(ac.pending) - agentMaxPending; over > 0 {
+		ac.pending = ac.pending[over:]
+	}
+}
+
+func (ac *agentClient) report() {
+	ac.mu.Lock()
+	batch := ac.pending
+	ac.pending = nil
+	ac.mu.Unlock()
+
+	if len(batch) == 0 {
+		return
+	}
+
+	err := ac.call("POST", "/api/agent/results", agentReport{Location: LOCATION, Results: batch}, nil)
+	if err == nil {
+		return
+	}
+
+	log.Printf("Error reporting %d results: %s", len(batch), err)
+
+	// put them back in front of what came in meanwhile
+	ac.mu.Lock()
+	ac.pending = append(batch, ac.pending...)
+	if over := len(ac.pending) - agentMaxPending; over > 0 {
+		ac.pending = ac.pending[over:]
+	}
+	ac.mu.Unlock()
+}
@@ -0,0 +1,303 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+type State string
+
+const (
+	StateUp       State = "up"
+	StateDegraded State = "degraded"
+	StateDown     State = "down"
+)
+
+// AlertRule decides when a probe changes state. A failed check counts
+// towards degraded and down, a successful but slow check or one with a
+// warning only degrades.
+type AlertRule struct {
+	DegradedAfter int           `yaml:"degraded_after" json:",omitempty"`
+	DownAfter     int           `yaml:"down_after" json:",omitempty"`
+	RecoverAfter  int           `yaml:"recover_after" json:",omitempty"`
+	MaxLatency    time.Duration `yaml:"max_latency" json:",omitempty"`
+	Remind        time.Duration `yaml:"remind" json:",omitempty"`
+	// Quorum is how many locations have to see a probe down, or not up,
+	// before it is down or degraded, default is a majority of the reporting
+	// locations.
+	Quorum int `yaml:"quorum" json:",omitempty"`
+}
+
+var defaultAlertRule = AlertRule{
+	DegradedAfter: 1,
+	DownAfter:     3,
+	RecoverAfter:  1,
+}
+
+// merge fills the zero fields of r from def.
+func (r AlertRule) merge(def AlertRule) AlertRule {
+	if r.DegradedAfter == 0 {
+		r.DegradedAfter = def.DegradedAfter
+	}
+	if r.DownAfter == 0 {
+		r.DownAfter = def.DownAfter
+	}
+	if r.RecoverAfter == 0 {
+		r.RecoverAfter = def.RecoverAfter
+	}
+	if r.MaxLatency == 0 {
+		r.MaxLatency = def.MaxLatency
+	}
+	if r.Remind == 0 {
+		r.Remind = def.Remind
+	}
+	if r.Quorum == 0 {
+		r.Quorum = def.Quorum
+	}
+	return r
+}
+
+// Event is what gets delivered to the notifiers.
+type Event struct {
+	Probe     string    `json:"probe"`
+	URL       string    `json:"url"`
+	From      State     `json:"from"`
+	To        State     `json:"to"`
+	Reminder  bool      `json:"reminder,omitempty"`
+	Since     time.Time `json:"since"`
+	Timestamp time.Time `json:"timestamp"`
+	Status    int       `json:"status"`
+	Duration  string    `json:"duration"`
+	Error     string    `json:"error,omitempty"`
+	// Location is where the result that caused the event came from,
+	// Failing lists the locations not seeing the probe up.
+	Location string   `json:"location,omitempty"`
+	Failing  []string `json:"failing,omitempty"`
+}
+
+func (e Event) Title() string {
+	switch {
+	case e.Reminder:
+		return fmt.Sprintf("[%s] %s still %s since %s", e.To, e.URL, e.To, e.Since.Format(time.RFC3339))
+	case e.To == StateUp:
+		return fmt.Sprintf("[recovered] %s is up after %s %s", e.URL, e.From, e.Timestamp.Sub(e.Since).Round(time.Second))
+	default:
+		return fmt.Sprintf("[%s] %s went %s", e.To, e.URL, e.To)
+	}
+}
+
+func (e Event) Text() string {
+	text := fmt.Sprintf("%s\nstatus: %d, duration: %s, at %s", e.Title(), e.Status, e.Duration, e.Timestamp.Format(time.RFC3339))
+	if e.Error != "" {
+		text += "\nerror: " + e.Error
+	}
+	if len(e.Failing) > 0 {
+		text += "\nfailing from: " + strings.Join(e.Failing, ", ")
+	}
+	return text
+}
+
+type alertState struct {
+	state        State
+	since        time.Time
+	fails        int
+	passes       int
+	lastNotified time.Time
+	seen         time.Time
+}
+
+// Alerter keeps an alert state machine per probe and location, and derives
+// the state of a probe from them: it is down once a quorum of the locations
+// that recently reported see it down, degraded once a quorum sees it down or
+// degraded. A single failing location only shows on the dashboard. Only
+// transitions of the probe state send an Event. Staying in a state never
+// notifies again, except for reminders while a probe is down.
+type Alerter struct {
+	mu        sync.Mutex
+	locations map[string]map[string]*alertState
+	probes    map[string]*alertState
+}
+
+var alerter = &Alerter{
+	locations: make(map[string]map[string]*alertState),
+	probes:    make(map[string]*alertState),
+}
+
+func (a *Alerter) State(uid string) State {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if st, ok := a.probes[uid]; ok {
+		return st.state
+	}
+	return StateUp
+}
+
+// LocationStates returns the state of the probe per location.
+func (a *Alerter) LocationStates(uid string) map[string]State {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	states := make(map[string]State)
+	for loc, st := range a.locations[uid] {
+		states[loc] = st.state
+	}
+	return states
+}
+
+func (a *Alerter) Forget(uid string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	delete(a.locations, uid)
+	delete(a.probes, uid)
+}
+
+// alertRule is the rule of p with the defaults of the config.
+func (p *Probe) alertRule() AlertRule {
+	def := currentConfig().Alert
+	if p.Alert != nil {
+		return p.Alert.merge(def)
+	}
+	return def
+}
+
+func (a *Alerter) Observe(p *Probe, location string, r Result) {
+	rule := p.alertRule()
+	// queried before locking, the store is a single connection
+	suppressed := store.InMaintenance(p.Uid, r.Timestamp)
+
+	a.mu.Lock()
+	if a.locations[p.Uid] == nil {
+		a.locations[p.Uid] = make(map[string]*alertState)
+	}
+	loc, ok := a.locations[p.Uid][location]
+	if !ok {
+		loc = &alertState{state: StateUp, since: r.Timestamp}
+		a.locations[p.Uid][location] = loc
+	}
+	loc.seen = r.Timestamp
+
+	switch {
+	case !r.Ok():
+		loc.fails++
+		loc.passes = 0
+		if loc.fails >= rule.DownAfter {
+			loc.state = StateDown
+		} else if loc.fails >= rule.DegradedAfter && loc.state == StateUp {
+			loc.state = StateDegraded
+		}
+	case r.Warning != "", rule.MaxLatency > 0 && r.Duration > rule.MaxLatency:
+		loc.fails = 0
+		loc.passes = 0
+		loc.state = StateDegraded
+	default:
+		loc.fails = 0
+		loc.passes++
+		if loc.passes >= rule.RecoverAfter {
+			loc.state = StateUp
+		}
+	}
+
+	next, failing := a.aggregate(p, rule, r.Timestamp)
+
+	st, ok := a.probes[p.Uid]
+	if !ok {
+		st = &alertState{state: StateUp, since: r.Timestamp}
+		a.probes[p.Uid] = st
+	}
+
+	event := Event{
+		Probe:     p.Uid,
+		URL:       p.DisplayName(),
+		From:      st.state,
+		To:        next,
+		Since:     st.since,
+		Timestamp: r.Timestamp,
+		Status:    r.Status,
+		Duration:  r.Duration.String(),
+		Error:     or(r.Error, r.Warning),
+		Location:  location,
+		Failing:   failing,
+	}
+
+	switch {
+	case next != st.state:
+	case next == StateDown && rule.Remind > 0 && r.Timestamp.Sub(st.lastNotified) >= rule.Remind:
+		event.Reminder = true
+	default:
+		a.mu.Unlock()
+		return
+	}
+
+	// a suppressed alert leaves the state as it was, so the transition or
+	// the reminder still fires once the window is over
+	if suppressed {
+		a.mu.Unlock()
+		log.Printf("Alert suppressed by maintenance: %s", event.Title())
+		return
+	}
+
+	if next != st.state {
+		st.state = next
+		st.since = r.Timestamp
+	}
+	st.lastNotified = r.Timestamp
+	a.mu.Unlock()
+
+	log.Printf("Alert: %s", event.Title())
+	go notifyAll(event)
+}
+
+// quorum is how many of total locations have to agree.
+func (r AlertRule) quorum(total int) int {
+	if r.Quorum <= 0 || r.Quorum > total {
+		return total/2 + 1
+	}
+	return r.Quorum
+}
+
+// aggregate derives the probe state from the locations that reported within
+// the last three intervals, and returns the locations that are not up.
+func (a *Alerter) aggregate(p *Probe, rule AlertRule, now time.Time) (State, []string) {
+	stale := 3 * p.interval()
+
+	var total, down, notUp int
+	var failing []string
+	for name, loc := range a.locations[p.Uid] {
+		if now.Sub(loc.seen) > stale {
+			continue
+		}
+
+		total++
+		if loc.state == StateDown {
+			down++
+		}
+		if loc.state != StateUp {
+			notUp++
+			failing = append(failing, or(name, "local"))
+		}
+	}
+	sort.Strings(failing)
+
+	quorum := rule.quorum(total)
+	switch {
+	case down >= quorum:
+		return StateDown, failing
+	case notUp >= quorum:
+		return StateDegraded, failing
+	default:
+		return StateUp, failing
+	}
+}
+
+func or(a, b string) string {
+	if a == "" {
+		return b
+	}
+	return a
+}
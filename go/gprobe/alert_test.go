@@ -0,0 +1,266 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func newAlerter() *Alerter {
+	return &Alerter{
+		locations: make(map[string]map[string]*alertState),
+		probes:    make(map[string]*alertState),
+	}
+}
+
+// checked is a result at t0+d: ok, fail, warn or slow.
+func checked(kind string, d time.Duration) Result {
+	r := Result{Timestamp: at(d), Status: 200, Duration: 10 * time.Millisecond}
+	switch kind {
+	case "fail":
+		r.Status, r.Error = 500, "unexpected status 500"
+	case "warn":
+		r.Warning = "certificate expires in 3 days"
+	case "slow":
+		r.Duration = 5 * time.Second
+	}
+	return r
+}
+
+type observation struct {
+	loc   string
+	at    time.Duration
+	check string
+	// the probe state afterwards and whether it notified
+	state State
+	sent  bool
+}
+
+func TestAlerter(t *testing.T) {
+	s := 30 * time.Second
+
+	tests := []struct {
+		name    string
+		rule    AlertRule
+		windows []Maintenance
+		steps   []observation
+	}{
+		{
+			name: "default thresholds",
+			steps: []observation{
+				{"", 0, "ok", StateUp, false},
+				{"", s, "fail", StateDegraded, true},
+				{"", 2 * s, "fail", StateDegraded, false},
+				{"", 3 * s, "fail", StateDown, true},
+				{"", 4 * s, "fail", StateDown, false},
+				{"", 5 * s, "ok", StateUp, true},
+				{"", 6 * s, "ok", StateUp, false},
+			},
+		},
+		{
+			name: "recover after",
+			rule: AlertRule{DownAfter: 1, RecoverAfter: 2},
+			steps: []observation{
+				{"", 0, "fail", StateDown, true},
+				{"", s, "ok", StateDown, false},
+				{"", 2 * s, "fail", StateDown, false},
+				{"", 3 * s, "ok", StateDown, false},
+				{"", 4 * s, "ok", StateUp, true},
+			},
+		},
+		{
+			name: "warnings and slow checks degrade",
+			rule: AlertRule{MaxLatency: time.Second},
+			steps: []observation{
+				{"", 0, "warn", StateDegraded, true},
+				{"", s, "ok", StateUp, true},
+				{"", 2 * s, "slow", StateDegraded, true},
+				{"", 3 * s, "slow", StateDegraded, false},
+				{"", 4 * s, "ok", StateUp, true},
+			},
+		},
+		{
+			name: "reminders while down",
+			rule: AlertRule{DownAfter: 1, Remind: time.Minute},
+			steps: []observation{
+				{"", 0, "fail", StateDown, true},
+				{"", s, "fail", StateDown, false},
+				{"", 2 * s, "fail", StateDown, true},
+				{"", 3 * s, "fail", StateDown, false},
+				{"", 4 * s, "fail", StateDown, true},
+				{"", 5 * s, "ok", StateUp, true},
+			},
+		},
+		{
+			name: "no reminders while degraded",
+			rule: AlertRule{DownAfter: 10, Remind: time.Minute},
+			steps: []observation{
+				{"", 0, "fail", StateDegraded, true},
+				{"", 2 * s, "fail", StateDegraded, false},
+				{"", 4 * s, "fail", StateDegraded, false},
+			},
+		},
+		{
+			name: "a majority of locations",
+			rule: AlertRule{DownAfter: 2},
+			steps: []observation{
+				{"ams", 0, "ok", StateUp, false},
+				{"fra", time.Second, "ok", StateUp, false},
+				{"nyc", 2 * time.Second, "ok", StateUp, false},
+				// one location alone changes nothing
+				{"ams", s, "fail", StateUp, false},
+				{"ams", 2 * s, "fail", StateUp, false},
+				{"fra", 2*s + time.Second, "ok", StateUp, false},
+				{"nyc", 2*s + 2*time.Second, "ok", StateUp, false},
+				// two of three are not up, then down
+				{"fra", 3 * s, "fail", StateDegraded, true},
+				{"nyc", 3*s + time.Second, "ok", StateDegraded, false},
+				{"ams", 3*s + 2*time.Second, "fail", StateDegraded, false},
+				{"fra", 4 * s, "fail", StateDown, true},
+				// ams alone is not a quorum
+				{"fra", 5 * s, "ok", StateUp, true},
+				{"ams", 5*s + time.Second, "ok", StateUp, false},
+			},
+		},
+		{
+			name: "explicit quorum",
+			rule: AlertRule{DownAfter: 1, Quorum: 1},
+			steps: []observation{
+				{"ams", 0, "ok", StateUp, false},
+				{"fra", time.Second, "ok", StateUp, false},
+				{"nyc", 2 * time.Second, "ok", StateUp, false},
+				{"ams", s, "fail", StateDown, true},
+			},
+		},
+		{
+			name: "a quorum over the total is a majority",
+			rule: AlertRule{DownAfter: 1, Quorum: 5},
+			steps: []observation{
+				{"ams", 0, "ok", StateUp, false},
+				{"fra", time.Second, "fail", StateUp, false},
+				{"nyc", 2 * time.Second, "fail", StateDown, true},
+			},
+		},
+		{
+			name: "stale locations don't count",
+			rule: AlertRule{DownAfter: 1},
+			steps: []observation{
+				{"ams", 0, "ok", StateUp, false},
+				{"fra", time.Second, "ok", StateUp, false},
+				{"nyc", 2 * time.Second, "ok", StateUp, false},
+				{"ams", s, "fail", StateUp, false},
+				// fra and nyc stopped reporting three intervals ago
+				{"ams", 4 * s, "fail", StateDown, true},
+			},
+		},
+		{
+			name:    "maintenance keeps the state until the window ends",
+			rule:    AlertRule{DownAfter: 1},
+			windows: []Maintenance{window(s, 3*s)},
+			steps: []observation{
+				{"", 0, "ok", StateUp, false},
+				{"", s, "fail", StateUp, false},
+				{"", 2 * s, "fail", StateUp, false},
+				{"", 3 * s, "fail", StateDown, true},
+			},
+		},
+		{
+			name:    "maintenance of other probes",
+			rule:    AlertRule{DownAfter: 1},
+			windows: []Maintenance{window(0, time.Hour, "other")},
+			steps: []observation{
+				{"", 0, "fail", StateDown, true},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := testStore(t)
+			for _, m := range tt.windows {
+				if err := s.AddMaintenance(m); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			a := newAlerter()
+			rule := tt.rule
+			p := &Probe{Uid: "p", URL: "https://example.com", Alert: &rule}
+			for i, o := range tt.steps {
+				r := checked(o.check, o.at)
+				a.Observe(p, o.loc, r)
+
+				sent := a.probes[p.Uid].lastNotified.Equal(r.Timestamp)
+				if state := a.State(p.Uid); state != o.state || sent != o.sent {
+					t.Fatalf("step %d, %s %s from %q: got %s, sent %v, want %s, sent %v",
+						i, o.check, r.Timestamp.Sub(t0), o.loc, state, sent, o.state, o.sent)
+				}
+			}
+		})
+	}
+}
+
+func TestAlerterEvents(t *testing.T) {
+	testStore(t)
+
+	events := make(chan Event, 10)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var e Event
+		if err := json.NewDecoder(r.Body).Decode(&e); err != nil {
+			t.Error(err)
+		}
+		events <- e
+	}))
+	defer srv.Close()
+
+	cfgMu.Lock()
+	saved := cfg
+	cfg.Notifiers = []NotifierConfig{{Type: "webhook", URL: srv.URL}}
+	cfgMu.Unlock()
+	defer func() {
+		cfgMu.Lock()
+		cfg = saved
+		cfgMu.Unlock()
+	}()
+
+	a := newAlerter()
+	p := &Probe{Uid: "p", URL: "https://example.com", Alert: &AlertRule{DownAfter: 1, Remind: time.Minute}}
+	want := []Event{
+		{From: StateUp, To: StateDown, Since: at(0), Location: "nyc", Failing: []string{"fra", "nyc"}},
+		{From: StateDown, To: StateDown, Reminder: true, Since: at(time.Second), Location: "nyc", Failing: []string{"fra", "nyc"}},
+		// ams and fra are stale by now
+		{From: StateDown, To: StateUp, Since: at(time.Second), Location: "nyc"},
+	}
+
+	// notifications are sent in the background, wait for each
+	next := func(i int) {
+		select {
+		case e := <-events:
+			w := want[i]
+			got := Event{From: e.From, To: e.To, Reminder: e.Reminder, Since: e.Since.In(time.Local), Location: e.Location, Failing: e.Failing}
+			if !reflect.DeepEqual(got, w) || e.Probe != "p" || e.URL != p.DisplayName() {
+				t.Errorf("event %d:\n got %+v\nwant %+v", i, got, w)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("event %d not sent", i)
+		}
+	}
+
+	a.Observe(p, "ams", checked("ok", 0))
+	a.Observe(p, "fra", checked("fail", 0))
+	a.Observe(p, "nyc", checked("fail", time.Second))
+	next(0)
+	a.Observe(p, "nyc", checked("fail", 30*time.Second))
+	a.Observe(p, "nyc", checked("fail", time.Minute+time.Second))
+	next(1)
+	a.Observe(p, "nyc", checked("ok", 2*time.Minute))
+	next(2)
+
+	select {
+	case e := <-events:
+		t.Errorf("unexpected event %+v", e)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"sort"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// probeView is a probe as returned by the API, with its latest result and
+// alert state.
+type probeView struct {
+	*Probe
+	Last  Result
+	State State
+}
+
+func viewOf(p *Probe) probeView {
+	return probeView{
+		Probe: p,
+		Last:  p.Last(),
+		State: alerter.State(p.Uid),
+	}
+}
+
+func apiListProbes(c *gin.Context) {
+	views := []probeView{}
+	for _, p := range gpm.GetProbes() {
+		views = append(views, viewOf(p))
+	}
+
+	sort.Slice(views, func(i, j int) bool {
+		return views[i].Uid < views[j].Uid
+	})
+
+	c.JSON(200, views)
+}
+
+func apiGetProbe(c *gin.Context) {
+	probe := gpm.GetProbe(c.Param("uid"))
+	if probe == nil {
+		c.JSON(404, gin.H{"error": "probe not found"})
+		return
+	}
+
+	c.JSON(200, viewOf(probe))
+}
+
+func apiCreateProbe(c *gin.Context) {
+	probe := &Probe{}
+	if err := c.ShouldBindJSON(probe); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+
+	probe.Uid = Uuid()
+	probe.serverOwned(nil)
+	if err := probe.Validate(); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+
+	_ = probe.Ping(true)
+
+	gpm.AddProbe(probe)
+	gpm.FlushFile()
+
+	c.JSON(201, viewOf(probe))
+}
+
+// apiUpdateProbe replaces the whole probe definition, results and alert
+// state are kept.
+func apiUpdateProbe(c *gin.Context) {
+	uid := c.Param("uid")
+
+	old := gpm.GetProbe(uid)
+	if old == nil {
+		c.JSON(404, gin.H{"error": "probe not found"})
+		return
+	}
+
+	probe := &Probe{}
+	if err := c.ShouldBindJSON(probe); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+
+	probe.Uid = uid
+	probe.serverOwned(old)
+	if err := probe.Validate(); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+	probe.setLast(old.Last())
+
+	gpm.AddProbe(probe)
+	gpm.FlushFile()
+
+	c.JSON(200, viewOf(probe))
+}
+
+// serverOwned resets the fields of a probe from the API that clients don't
+// set: results only come from checks, and only the config sync manages and
+// archives probes. They are kept from old on updates.
+func (p *Probe) serverOwned(old *Probe) {
+	p.Results = nil
+	p.Managed, p.Archived = false, false
+	if old != nil {
+		p.Managed, p.Archived = old.Managed, old.Archived
+	}
+}
+
+func apiDeleteProbe(c *gin.Context) {
+	probe := gpm.GetProbe(c.Param("uid"))
+	if probe == nil {
+		c.JSON(404, gin.H{"error": "probe not found"})
+		return
+	}
+
+	gpm.DeleteProbe(probe)
+
+	c.Status(204)
+}
+
+func apiProbeResults(c *gin.Context) {
+	probe := gpm.GetProbe(c.Param("uid"))
+	if probe == nil {
+		c.JSON(404, gin.H{"error": "probe not found"})
+		return
+	}
+
+	window, err := parseWindow(c.DefaultQuery("range", "1h"))
+	if err != nil {
+		c.JSON(400, gin.H{"error": "invalid range: " + err.Error()})
+		return
+	}
+
+	to := time.Now()
+	points, err := store.Range(probe.Uid, probe.interval(), to.Add(-window), to)
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	if points == nil {
+		points = []Point{}
+	}
+
+	c.JSON(200, points)
+}
+
+func apiProbeStats(c *gin.Context) {
+	probe := gpm.GetProbe(c.Param("uid"))
+	if probe == nil {
+		c.JSON(404, gin.H{"error": "probe not found"})
+		return
+	}
+
+	stats, err := ProbeStats(probe, parseWindows(c.Query("windows")))
+	if err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(200, stats)
+}
@@ -0,0 +1,274 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+const defaultTimeout = 5 * time.Second
+
+// Checker runs a single check of a probe, Probe.Type picks the
+// implementation. Probe.URL is the target in whatever form the checker
+// expects.
+type Checker interface {
+	Check(p *Probe) Result
+}
+
+var checkers = map[string]Checker{
+	"http": httpChecker{},
+	"tcp":  tcpChecker{},
+	"dns":  dnsChecker{},
+	"tls":  tlsChecker{},
+	"grpc": grpcChecker{},
+}
+
+var checkerTypes = []string{"http", "tcp", "dns", "tls", "grpc"}
+
+func checkerFor(typ string) (Checker, error) {
+	if typ == "" {
+		typ = "http"
+	}
+
+	c, ok := checkers[typ]
+	if !ok {
+		return nil, fmt.Errorf("unknown probe type %q", typ)
+	}
+	return c, nil
+}
+
+// DNSOptions configures the dns check, the target is the name to resolve.
+type DNSOptions struct {
+	// Resolver is host:port of the DNS server, the system resolver is used
+	// when empty.
+	Resolver string `yaml:"resolver" json:",omitempty"`
+	// RecordType is one of A, AAAA, CNAME, MX, NS and TXT, default A.
+	RecordType string `yaml:"record_type" json:",omitempty"`
+	// Expect must be one of the answers when set.
+	Expect string `yaml:"expect" json:",omitempty"`
+}
+
+// TLSOptions configures the tls check, the target is host:port.
+type TLSOptions struct {
+	// WarnDays marks the result with a warning when the certificate expires
+	// within that many days, default 14.
+	WarnDays int `yaml:"warn_days" json:",omitempty"`
+}
+
+// GRPCOptions configures the grpc health check, the target is host:port.
+type GRPCOptions struct {
+	Service string `yaml:"service" json:",omitempty"`
+	TLS     bool   `yaml:"tls" json:",omitempty"`
+}
+
+type httpChecker struct{}
+
+func (httpChecker) Check(p *Probe) Result {
+	return p.pingHTTP()
+}
+
+// tcpChecker only connects, it is the ping for hosts that drop ICMP or when
+// raw sockets are not available.
+type tcpChecker struct{}
+
+func (tcpChecker) Check(p *Probe) Result {
+	start := time.Now()
+	result := Result{Timestamp: start}
+
+	conn, err := net.DialTimeout("tcp", p.URL, p.timeout())
+	result.Duration = time.Since(start)
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	conn.Close()
+
+	if p.Assert.MaxLatency > 0 && result.Duration > p.Assert.MaxLatency {
+		result.Error = fmt.Sprintf("latency %s over %s", result.Duration.Round(time.Millisecond), p.Assert.MaxLatency)
+	}
+
+	return result
+}
+
+type dnsChecker struct{}
+
+func (dnsChecker) Check(p *Probe) Result {
+	start := time.Now()
+	result := Result{Timestamp: start}
+
+	r := net.DefaultResolver
+	if p.DNS.Resolver != "" {
+		r = &net.Resolver{
+			PreferGo: true,
+			Dial: func(ctx context.Context, network, _ string) (net.Conn, error) {
+				var d net.Dialer
+				return d.DialContext(ctx, network, p.DNS.Resolver)
+			},
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), p.timeout())
+	defer cancel()
+
+	answers, err := lookup(ctx, r, p.DNS.RecordType, p.URL)
+	result.Duration = time.Since(start)
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+
+	if len(answers) == 0 {
+		result.Error = "no answers"
+		return result
+	}
+
+	if p.DNS.Expect != "" && !contains(answers, p.DNS.Expect) {
+		result.Error = fmt.Sprintf("%s not in answers %s", p.DNS.Expect, strings.Join(answers, ", "))
+	}
+
+	return result
+}
+
+func lookup(ctx context.Context, r *net.Resolver, typ, name string) ([]string, error) {
+	var answers []string
+
+	switch strings.ToUpper(typ) {
+	case "", "A", "AAAA":
+		network := "ip4"
+		if strings.EqualFold(typ, "AAAA") {
+			network = "ip6"
+		}
+		ips, err := r.LookupIP(ctx, network, name)
+		if err != nil {
+			return nil, err
+		}
+		for _, ip := range ips {
+			answers = append(answers, ip.String())
+		}
+	case "CNAME":
+		cname, err := r.LookupCNAME(ctx, name)
+		if err != nil {
+			return nil, err
+		}
+		answers = append(answers, strings.TrimSuffix(cname, "."))
+	case "MX":
+		mxs, err := r.LookupMX(ctx, name)
+		if err != nil {
+			return nil, err
+		}
+		for _, mx := range mxs {
+			answers = append(answers, strings.TrimSuffix(mx.Host, "."))
+		}
+	case "NS":
+		nss, err := r.LookupNS(ctx, name)
+		if err != nil {
+			return nil, err
+		}
+		for _, ns := range nss {
+			answers = append(answers, strings.TrimSuffix(ns.Host, "."))
+		}
+	case "TXT":
+		return r.LookupTXT(ctx, name)
+	default:
+		return nil, fmt.Errorf("unsupported record type %q", typ)
+	}
+
+	return answers, nil
+}
+
+func contains(ss []string, s string) bool {
+	for _, v := range ss {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+type tlsChecker struct{}
+
+func (tlsChecker) Check(p *Probe) Result {
+	start := time.Now()
+	result := Result{Timestamp: start}
+
+	dialer := &net.Dialer{Timeout: p.timeout()}
+	conn, err := tls.DialWithDialer(dialer, "tcp", p.URL, &tls.Config{
+		InsecureSkipVerify: p.Request.InsecureSkipVerify,
+	})
+	result.Duration = time.Since(start)
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	defer conn.Close()
+
+	certs := conn.ConnectionState().PeerCertificates
+	if len(certs) == 0 {
+		result.Error = "no peer certificate"
+		return result
+	}
+
+	warnDays := p.TLS.WarnDays
+	if warnDays == 0 {
+		warnDays = 14
+	}
+
+	left := time.Until(certs[0].NotAfter)
+	days := int(left.Hours() / 24)
+	switch {
+	case left <= 0:
+		result.Error = fmt.Sprintf("certificate expired at %s", certs[0].NotAfter.Format(time.RFC3339))
+	case days < warnDays:
+		result.Warning = fmt.Sprintf("certificate expires in %d days", days)
+	}
+
+	return result
+}
+
+type grpcChecker struct{}
+
+func (grpcChecker) Check(p *Probe) Result {
+	start := time.Now()
+	result := Result{Timestamp: start}
+
+	creds := insecure.NewCredentials()
+	if p.GRPC.TLS {
+		creds = credentials.NewTLS(&tls.Config{
+			InsecureSkipVerify: p.Request.InsecureSkipVerify,
+		})
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), p.timeout())
+	defer cancel()
+
+	conn, err := grpc.DialContext(ctx, p.URL, grpc.WithTransportCredentials(creds), grpc.WithBlock())
+	if err != nil {
+		result.Duration = time.Since(start)
+		result.Error = err.Error()
+		return result
+	}
+	defer conn.Close()
+
+	resp, err := healthpb.NewHealthClient(conn).Check(ctx, &healthpb.HealthCheckRequest{
+		Service: p.GRPC.Service,
+	})
+	result.Duration = time.Since(start)
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+
+	if resp.Status != healthpb.HealthCheckResponse_SERVING {
+		result.Error = "health status " + resp.Status.String()
+	}
+
+	return result
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"log"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sync"
+	"syscall"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+type Config struct {
+	Alert     AlertRule        `yaml:"alert"`
+	Notifiers []NotifierConfig `yaml:"notifiers"`
+
+	// Probes are synced into the probe list, see SyncProbes.
+	Probes []ProbeSpec `yaml:"probes"`
+	// Prune is what happens to synced probes removed from the file:
+	// "archive" (default) keeps them and their results but stops checking,
+	// "delete" removes them.
+	Prune string `yaml:"prune"`
+}
+
+var (
+	cfgFile = filepath.Join(HOMEDIR, ".gprobe.yaml")
+
+	cfgMu sync.RWMutex
+	cfg   = Config{
+		Alert: defaultAlertRule,
+	}
+)
+
+func currentConfig() Config {
+	cfgMu.RLock()
+	defer cfgMu.RUnlock()
+
+	return cfg
+}
+
+// LoadConfig reads the optional config file, a missing file keeps the
+// defaults and reports false.
+func LoadConfig(path string) (bool, error) {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	next := Config{
+		Alert: defaultAlertRule,
+	}
+	if err := yaml.Unmarshal(data, &next); err != nil {
+		return false, err
+	}
+
+	cfgMu.Lock()
+	cfg = next
+	cfgMu.Unlock()
+
+	log.Printf("Loaded config from %s, %d notifiers, %d probes", path, len(next.Notifiers), len(next.Probes))
+	return true, nil
+}
+
+// WatchConfig reloads the config file and syncs its probes when the file
+// changes or on SIGHUP. The file is polled instead of watched, editors and
+// git replace files rather than writing them in place.
+func WatchConfig(path string) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+
+	var modTime time.Time
+	if fi, err := os.Stat(path); err == nil {
+		modTime = fi.ModTime()
+	}
+
+	tick := time.NewTicker(5 * time.Second)
+	defer tick.Stop()
+
+	for {
+		select {
+		case <-tick.C:
+			fi, err := os.Stat(path)
+			if err != nil || fi.ModTime().Equal(modTime) {
+				continue
+			}
+			modTime = fi.ModTime()
+		case <-hup:
+		}
+
+		reloadConfig(path)
+	}
+}
+
+func reloadConfig(path string) {
+	ok, err := LoadConfig(path)
+	if err != nil {
+		// keep running with the previous config
+		log.Printf("Error reloading config %s: %s", path, err)
+		return
+	}
+
+	if ok {
+		SyncProbes(currentConfig())
+	}
+}
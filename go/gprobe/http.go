@@ -0,0 +1,208 @@
+package main
+
+import (
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// maxBodySize caps how much of a response is read for body assertions.
+const maxBodySize = 1 << 20
+
+// insecureTransport is shared by the probes that skip TLS verification, so
+// their idle connections are reused instead of leaking with a transport per
+// check.
+var insecureTransport = func() *http.Transport {
+	t := http.DefaultTransport.(*http.Transport).Clone()
+	t.TLSClientConfig = &tls.Config{InsecureSkipVerify: true}
+	return t
+}()
+
+// HTTPRequest holds what is sent with a probe besides its method and URL.
+type HTTPRequest struct {
+	Headers            map[string]string `yaml:"headers" json:",omitempty"`
+	Body               string            `yaml:"body" json:",omitempty"`
+	BasicUser          string            `yaml:"basic_user" json:",omitempty"`
+	BasicPassword      string            `yaml:"basic_password" json:",omitempty"`
+	BearerToken        string            `yaml:"bearer_token" json:",omitempty"`
+	InsecureSkipVerify bool              `yaml:"insecure" json:",omitempty"`
+}
+
+// Assertions are checked against every response, the first one that does not
+// hold becomes the failure reason of the result.
+type Assertions struct {
+	// Status is a comma separated list of codes and ranges, e.g.
+	// "200-299,301". Empty means any status below 400.
+	Status       string        `yaml:"status" json:",omitempty"`
+	BodyContains string        `yaml:"body_contains" json:",omitempty"`
+	BodyRegex    string        `yaml:"body_regex" json:",omitempty"`
+	JSONPath     string        `yaml:"json_path" json:",omitempty"`
+	JSONValue    string        `yaml:"json_value" json:",omitempty"`
+	MaxLatency   time.Duration `yaml:"max_latency" json:",omitempty"`
+}
+
+func (a Assertions) needBody() bool {
+	return a.BodyContains != "" || a.BodyRegex != "" || a.JSONPath != ""
+}
+
+func (p *Probe) pingHTTP() Result {
+	start := time.Now()
+	result := Result{Timestamp: start}
+
+	req, err := http.NewRequest(p.Method, p.URL, strings.NewReader(p.Request.Body))
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+
+	for k, v := range p.Request.Headers {
+		req.Header.Set(k, v)
+	}
+	if p.Request.BasicUser != "" {
+		req.SetBasicAuth(p.Request.BasicUser, p.Request.BasicPassword)
+	}
+	if p.Request.BearerToken != "" {
+		req.Header.Set("Authorization", "Bearer "+p.Request.BearerToken)
+	}
+
+	httpc := http.Client{
+		Timeout: p.timeout(),
+	}
+	if p.Request.InsecureSkipVerify {
+		httpc.Transport = insecureTransport
+	}
+
+	resp, err := httpc.Do(req)
+	if err != nil {
+		result.Duration = time.Since(start)
+		result.Error = err.Error()
+		return result
+	}
+	defer resp.Body.Close()
+
+	result.Status = resp.StatusCode
+
+	var body []byte
+	if p.Assert.needBody() {
+		body, err = io.ReadAll(io.LimitReader(resp.Body, maxBodySize))
+		if err != nil {
+			result.Duration = time.Since(start)
+			result.Error = "reading body: " + err.Error()
+			return result
+		}
+	}
+
+	result.Duration = time.Since(start)
+
+	if err := p.Assert.check(result, body); err != nil {
+		result.Error = err.Error()
+	}
+
+	return result
+}
+
+func (a Assertions) check(r Result, body []byte) error {
+	ok, err := statusMatches(a.Status, r.Status)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return fmt.Errorf("unexpected status %d", r.Status)
+	}
+
+	if a.MaxLatency > 0 && r.Duration > a.MaxLatency {
+		return fmt.Errorf("latency %s over %s", r.Duration.Round(time.Millisecond), a.MaxLatency)
+	}
+
+	if a.BodyContains != "" && !strings.Contains(string(body), a.BodyContains) {
+		return fmt.Errorf("body does not contain %q", a.BodyContains)
+	}
+
+	if a.BodyRegex != "" {
+		re, err := regexp.Compile(a.BodyRegex)
+		if err != nil {
+			return fmt.Errorf("invalid body regex: %w", err)
+		}
+		if !re.Match(body) {
+			return fmt.Errorf("body does not match %q", a.BodyRegex)
+		}
+	}
+
+	if a.JSONPath != "" {
+		var v any
+		if err := json.Unmarshal(body, &v); err != nil {
+			return fmt.Errorf("body is not json: %w", err)
+		}
+
+		got, ok := jsonLookup(v, a.JSONPath)
+		if !ok {
+			return fmt.Errorf("json path %s not found", a.JSONPath)
+		}
+		if a.JSONValue != "" && fmt.Sprint(got) != a.JSONValue {
+			return fmt.Errorf("json path %s is %v, want %s", a.JSONPath, got, a.JSONValue)
+		}
+	}
+
+	return nil
+}
+
+// statusMatches checks status against a spec like "200-299,301".
+func statusMatches(spec string, status int) (bool, error) {
+	if spec == "" {
+		return status < 400, nil
+	}
+
+	for _, part := range strings.Split(spec, ",") {
+		part = strings.TrimSpace(part)
+		lo, hi, isRange := strings.Cut(part, "-")
+		if !isRange {
+			hi = lo
+		}
+
+		min, err := strconv.Atoi(strings.TrimSpace(lo))
+		if err != nil {
+			return false, fmt.Errorf("invalid status spec %q", spec)
+		}
+		max, err := strconv.Atoi(strings.TrimSpace(hi))
+		if err != nil {
+			return false, fmt.Errorf("invalid status spec %q", spec)
+		}
+
+		if status >= min && status <= max {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+// jsonLookup walks a dot separated path, numeric segments index arrays:
+// "data.items.0.name".
+func jsonLookup(v any, path string) (any, bool) {
+	for _, key := range strings.Split(path, ".") {
+		switch node := v.(type) {
+		case map[string]any:
+			next, ok := node[key]
+			if !ok {
+				return nil, false
+			}
+			v = next
+		case []any:
+			i, err := strconv.Atoi(key)
+			if err != nil || i < 0 || i >= len(node) {
+				return nil, false
+			}
+			v = node[i]
+		default:
+			return nil, false
+		}
+	}
+
+	return v, true
+}
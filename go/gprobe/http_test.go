@@ -0,0 +1,149 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestStatusMatches(t *testing.T) {
+	tests := []struct {
+		spec    string
+		status  int
+		want    bool
+		wantErr bool
+	}{
+		{"", 200, true, false},
+		{"", 399, true, false},
+		{"", 404, false, false},
+		{"", 0, true, false},
+		{"200", 200, true, false},
+		{"200", 201, false, false},
+		{"200-299", 204, true, false},
+		{"200-299", 300, false, false},
+		{"200-299,301", 301, true, false},
+		{" 200 - 204 , 404 ", 404, true, false},
+		{"404", 404, true, false},
+		{"2xx", 200, false, true},
+		{"200-", 200, false, true},
+		{"200,,204", 204, false, true},
+	}
+	for _, tt := range tests {
+		got, err := statusMatches(tt.spec, tt.status)
+		if got != tt.want || (err != nil) != tt.wantErr {
+			t.Errorf("statusMatches(%q, %d) = %v, %v, want %v, error %v", tt.spec, tt.status, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestJSONLookup(t *testing.T) {
+	var v any
+	if err := json.Unmarshal([]byte(`{"status": "ok", "data": {"items": [{"name": "foo"}, {"name": "bar", "tags": []}]}, "count": 2, "nil": null}`), &v); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		path   string
+		want   string
+		wantOk bool
+	}{
+		{"status", "ok", true},
+		{"data.items.1.name", "bar", true},
+		{"data.items.0", "map[name:foo]", true},
+		{"count", "2", true},
+		{"nil", "<nil>", true},
+		{"missing", "", false},
+		{"data.items.2.name", "", false},
+		{"data.items.-1", "", false},
+		{"data.items.first", "", false},
+		{"status.length", "", false},
+		{"data.items.1.tags.0", "", false},
+	}
+	for _, tt := range tests {
+		got, ok := jsonLookup(v, tt.path)
+		if ok != tt.wantOk || (ok && fmt.Sprint(got) != tt.want) {
+			t.Errorf("jsonLookup(%q) = %v, %v, want %s, %v", tt.path, got, ok, tt.want, tt.wantOk)
+		}
+	}
+}
+
+func TestAssertions(t *testing.T) {
+	body := []byte(`{"status": "ok", "version": "1.2.3"}`)
+	ok := Result{Status: 200, Duration: 50 * time.Millisecond}
+
+	tests := []struct {
+		name    string
+		assert  Assertions
+		result  Result
+		body    []byte
+		wantErr string
+	}{
+		{"defaults", Assertions{}, ok, body, ""},
+		{"error status", Assertions{}, Result{Status: 503}, body, "unexpected status 503"},
+		{"expected status", Assertions{Status: "503"}, Result{Status: 503}, body, ""},
+		{"invalid status spec", Assertions{Status: "ok"}, ok, body, `invalid status spec "ok"`},
+		{"max latency", Assertions{MaxLatency: 10 * time.Millisecond}, ok, body, "latency 50ms over 10ms"},
+		{"body contains", Assertions{BodyContains: `"ok"`}, ok, body, ""},
+		{"body misses", Assertions{BodyContains: "error"}, ok, body, `body does not contain "error"`},
+		{"body regex", Assertions{BodyRegex: `"version": "1\.\d+`}, ok, body, ""},
+		{"body regex mismatch", Assertions{BodyRegex: `"version": "2\.`}, ok, body, `body does not match "\"version\": \"2\\."`},
+		{"invalid regex", Assertions{BodyRegex: "("}, ok, body, "invalid body regex"},
+		{"json value", Assertions{JSONPath: "status", JSONValue: "ok"}, ok, body, ""},
+		{"json path only", Assertions{JSONPath: "version"}, ok, body, ""},
+		{"json value mismatch", Assertions{JSONPath: "status", JSONValue: "down"}, ok, body, "json path status is ok, want down"},
+		{"json path missing", Assertions{JSONPath: "data.status"}, ok, body, "json path data.status not found"},
+		{"not json", Assertions{JSONPath: "status"}, ok, []byte("<html>"), "body is not json"},
+		// the first failing assertion is reported
+		{"status first", Assertions{Status: "200", BodyContains: "error"}, Result{Status: 500}, body, "unexpected status 500"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.assert.check(tt.result, tt.body)
+			switch {
+			case tt.wantErr == "" && err != nil:
+				t.Errorf("unexpected error %v", err)
+			case tt.wantErr != "" && (err == nil || !strings.HasPrefix(err.Error(), tt.wantErr)):
+				t.Errorf("got error %v, want %s", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestPingHTTP(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if u, p, _ := r.BasicAuth(); u != "alice" || p != "secret" || r.Header.Get("X-Probe") != "1" || r.Method != http.MethodPost {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		w.Write([]byte(`{"status": "ok"}`))
+	}))
+	defer srv.Close()
+
+	p := &Probe{
+		URL:    srv.URL,
+		Method: http.MethodPost,
+		Request: HTTPRequest{
+			Headers:       map[string]string{"X-Probe": "1"},
+			BasicUser:     "alice",
+			BasicPassword: "secret",
+		},
+		Assert: Assertions{JSONPath: "status", JSONValue: "ok"},
+	}
+	if r := p.pingHTTP(); r.Status != 200 || r.Error != "" {
+		t.Errorf("got %d %q, want 200 without error", r.Status, r.Error)
+	}
+
+	p.Request.BasicPassword = "guess"
+	if r := p.pingHTTP(); r.Status != 403 || r.Error != "unexpected status 403" {
+		t.Errorf("got %d %q, want 403 as the error", r.Status, r.Error)
+	}
+
+	srv.Close()
+	if r := p.pingHTTP(); r.Status != 0 || r.Error == "" {
+		t.Errorf("got %d %q from a closed server, want status 0 and the error", r.Status, r.Error)
+	}
+}
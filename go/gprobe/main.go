@@ -3,12 +3,17 @@ package main
 import (
 	"embed"
 	"encoding/json"
+	"fmt"
 	"html/template"
 	"io/fs"
 	"log"
 	"net/http"
 	"os"
 	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
 	"sync"
 	"time"
 
@@ -29,6 +34,12 @@ var (
 		"sub": func(a, b int) int {
 			return a - b
 		},
+		"ms": func(d time.Duration) string {
+			return strconv.FormatInt(d.Milliseconds(), 10) + "ms"
+		},
+		"pct": func(f float64) string {
+			return strconv.FormatFloat(f, 'f', 3, 64) + "%"
+		},
 	}
 
 	tmpl = template.Must(template.New("").Funcs(tmplFuncs).ParseFS(FS, "tmpl/*.html"))
@@ -37,11 +48,43 @@ var (
 
 	defaultFile = filepath.Join(HOMEDIR, ".gprobe.json")
 
+	dbFile = filepath.Join(HOMEDIR, ".gprobe.db")
+
+	store *Store
+
 	PORT = os.Getenv("PORT")
+
+	// WORKERS is the number of probes that can run at the same time.
+	WORKERS = os.Getenv("WORKERS")
+
+	// LOCATION names where this server runs its checks from, AGENT_TOKEN
+	// enables remote agents, see agent.go.
+	LOCATION    = or(os.Getenv("LOCATION"), "local")
+	AGENT_TOKEN = os.Getenv("AGENT_TOKEN")
 )
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "agent" {
+		runAgent(os.Args[2:])
+		return
+	}
+
+	hasConfig, err := LoadConfig(cfgFile)
+	if err != nil {
+		log.Fatalf("Error loading config %s: %s", cfgFile, err)
+	}
+
+	store, err = OpenStore(dbFile)
+	if err != nil {
+		log.Fatalf("Error opening store %s: %s", dbFile, err)
+	}
+	defer store.Close()
+
 	gpm.InitFromFile()
+	if hasConfig {
+		SyncProbes(currentConfig())
+	}
+	go WatchConfig(cfgFile)
 
 	gin.SetMode(gin.ReleaseMode)
 	r := gin.Default()
@@ -56,9 +99,45 @@ func main() {
 	r.POST("/probe/delete/:uid", removeProbeHandler)
 	r.GET("/ping/:uid", pingPageHandler)
 	r.GET("/ping/:uid/latest", latestPingHandler)
+	r.GET("/ping/:uid/results", resultsHandler)
+	r.GET("/ping/:uid/stats", statsHandler)
+	r.GET("/stats", allStatsHandler)
+
+	r.GET("/metrics", metricsHandler)
+
+	api := r.Group("/api")
+	api.GET("/probes", apiListProbes)
+	api.POST("/probes", apiCreateProbe)
+	api.GET("/probes/:uid", apiGetProbe)
+	api.PUT("/probes/:uid", apiUpdateProbe)
+	api.DELETE("/probes/:uid", apiDeleteProbe)
+	api.GET("/probes/:uid/results", apiProbeResults)
+	api.GET("/probes/:uid/stats", apiProbeStats)
+
+	agent := api.Group("/agent", agentAuth)
+	agent.POST("/register", agentRegisterHandler)
+	agent.GET("/probes", agentProbesHandler)
+	agent.POST("/results", agentResultsHandler)
+
+	r.GET("/status", statusPageHandler)
+	r.GET("/incidents", incidentsPageHandler)
+	r.POST("/incidents", createIncidentHandler)
+	r.POST("/incidents/:id", updateIncidentHandler)
+	r.POST("/maintenance", addMaintenanceHandler)
+	r.POST("/maintenance/delete/:id", deleteMaintenanceHandler)
+
+	workers, err := strconv.Atoi(WORKERS)
+	if err != nil || workers <= 0 {
+		workers = 16
+	}
+	NewScheduler(workers).Start()
 
 	c := cron.New()
-	c.AddFunc("@every 30s", gpm.CronProbe)
+	c.AddFunc("@every 5m", func() {
+		if err := store.Compact(); err != nil {
+			log.Printf("Error compacting store: %s", err)
+		}
+	})
 	c.Start()
 
 	r.Run(":" + PORT)
@@ -73,7 +152,40 @@ func pingPageHandler(c *gin.Context) {
 		return
 	}
 
-	c.HTML(200, "ping.html", probe)
+	stats, err := ProbeStats(probe, defaultWindows)
+	if err != nil {
+		log.Printf("Error computing stats of %s: %s", probe.URL, err)
+	}
+
+	latest, err := store.LatestByLocation(uid)
+	if err != nil {
+		log.Printf("Error querying results of %s: %s", probe.URL, err)
+	}
+	states := alerter.LocationStates(uid)
+
+	var locations []LocationResult
+	for loc, r := range latest {
+		if loc == "" {
+			// results from before locations were recorded
+			continue
+		}
+		locations = append(locations, LocationResult{Location: loc, State: states[loc], Last: r})
+	}
+	sort.Slice(locations, func(i, j int) bool {
+		return locations[i].Location < locations[j].Location
+	})
+
+	c.HTML(200, "ping.html", gin.H{
+		"Probe":     probe,
+		"Stats":     stats,
+		"Locations": locations,
+	})
+}
+
+type LocationResult struct {
+	Location string
+	State    State
+	Last     Result
 }
 
 func latestPingHandler(c *gin.Context) {
@@ -92,8 +204,98 @@ func latestPingHandler(c *gin.Context) {
 	})
 }
 
+func resultsHandler(c *gin.Context) {
+	uid := c.Param("uid")
+
+	probe := gpm.GetProbe(uid)
+	if probe == nil {
+		c.String(400, "Invalid probe ID")
+		return
+	}
+
+	window, err := parseWindow(c.DefaultQuery("range", "1h"))
+	if err != nil {
+		c.String(400, "Invalid range: %s", err)
+		return
+	}
+
+	to := time.Now()
+	points, err := store.Range(uid, probe.interval(), to.Add(-window), to)
+	if err != nil {
+		c.String(500, "Error querying results: %s", err)
+		return
+	}
+
+	c.JSON(200, points)
+}
+
+// parseWindow is time.ParseDuration with an extra "d" unit for days.
+func parseWindow(s string) (time.Duration, error) {
+	if days, ok := strings.CutSuffix(s, "d"); ok {
+		n, err := strconv.Atoi(days)
+		if err != nil {
+			return 0, err
+		}
+		return time.Duration(n) * 24 * time.Hour, nil
+	}
+
+	return time.ParseDuration(s)
+}
+
+func statsHandler(c *gin.Context) {
+	uid := c.Param("uid")
+
+	probe := gpm.GetProbe(uid)
+	if probe == nil {
+		c.String(400, "Invalid probe ID")
+		return
+	}
+
+	stats, err := ProbeStats(probe, parseWindows(c.Query("windows")))
+	if err != nil {
+		c.String(400, "Error computing stats: %s", err)
+		return
+	}
+
+	c.JSON(200, stats)
+}
+
+func allStatsHandler(c *gin.Context) {
+	windows := parseWindows(c.Query("windows"))
+
+	all := make(map[string][]Stats)
+	for uid, probe := range gpm.GetProbes() {
+		stats, err := ProbeStats(probe, windows)
+		if err != nil {
+			c.String(400, "Error computing stats: %s", err)
+			return
+		}
+		all[uid] = stats
+	}
+
+	c.JSON(200, all)
+}
+
 func indexPageHandler(c *gin.Context) {
-	c.HTML(200, "index.html", gpm.GetProbes())
+	probes := gpm.GetProbes()
+
+	stats := make(map[string][]Stats)
+	for uid, probe := range probes {
+		s, err := ProbeStats(probe, defaultWindows)
+		if err != nil {
+			log.Printf("Error computing stats of %s: %s", probe.URL, err)
+			continue
+		}
+		stats[uid] = s
+	}
+
+	c.HTML(200, "index.html", gin.H{
+		"Types":   checkerTypes,
+		"Probes":  probes,
+		"Alerter": alerter,
+		"Stats":   stats,
+		"Windows": defaultWindows,
+	})
 }
 
 func addProbeHandler(c *gin.Context) {
@@ -102,8 +304,21 @@ func addProbeHandler(c *gin.Context) {
 
 	probe := &Probe{
 		Uid:    Uuid(),
+		Type:   c.Request.FormValue("type"),
 		URL:    url,
 		Method: method,
+		Name:   c.Request.FormValue("name"),
+		Group:  c.Request.FormValue("group"),
+	}
+
+	if err := parseCheckForm(c, probe); err != nil {
+		c.String(400, "Invalid probe: %s", err)
+		return
+	}
+
+	if err := probe.Validate(); err != nil {
+		c.String(400, "Invalid probe: %s", err)
+		return
 	}
 
 	_ = probe.Ping(true)
@@ -114,6 +329,72 @@ func addProbeHandler(c *gin.Context) {
 	c.Redirect(302, "/")
 }
 
+// parseCheckForm reads the optional request and assertion fields of the add
+// probe form.
+func parseCheckForm(c *gin.Context, p *Probe) error {
+	for _, line := range strings.Split(c.PostForm("headers"), "\n") {
+		k, v, ok := strings.Cut(line, ":")
+		if !ok {
+			continue
+		}
+		if p.Request.Headers == nil {
+			p.Request.Headers = make(map[string]string)
+		}
+		p.Request.Headers[strings.TrimSpace(k)] = strings.TrimSpace(v)
+	}
+
+	p.Request.Body = c.PostForm("body")
+	p.Request.BasicUser = c.PostForm("basic_user")
+	p.Request.BasicPassword = c.PostForm("basic_password")
+	p.Request.BearerToken = c.PostForm("bearer_token")
+	p.Request.InsecureSkipVerify = c.PostForm("insecure") != ""
+
+	p.Assert.Status = c.PostForm("expect_status")
+	p.Assert.BodyContains = c.PostForm("body_contains")
+	p.Assert.BodyRegex = c.PostForm("body_regex")
+
+	p.Assert.JSONPath = c.PostForm("json_path")
+	p.Assert.JSONValue = c.PostForm("json_value")
+
+	p.DNS.Resolver = c.PostForm("dns_resolver")
+	p.DNS.RecordType = c.PostForm("dns_type")
+	p.DNS.Expect = c.PostForm("dns_expect")
+
+	if days := c.PostForm("tls_warn_days"); days != "" {
+		n, err := strconv.Atoi(days)
+		if err != nil {
+			return fmt.Errorf("invalid warn days %q", days)
+		}
+		p.TLS.WarnDays = n
+	}
+
+	p.GRPC.Service = c.PostForm("grpc_service")
+	p.GRPC.TLS = c.PostForm("grpc_tls") != ""
+
+	for field, d := range map[string]*time.Duration{
+		"interval": &p.Interval,
+		"timeout":  &p.Timeout,
+	} {
+		if secs := c.PostForm(field); secs != "" {
+			n, err := strconv.Atoi(secs)
+			if err != nil || n <= 0 {
+				return fmt.Errorf("invalid %s %q", field, secs)
+			}
+			*d = time.Duration(n) * time.Second
+		}
+	}
+
+	if ms := c.PostForm("max_latency"); ms != "" {
+		n, err := strconv.Atoi(ms)
+		if err != nil {
+			return fmt.Errorf("invalid max latency %q", ms)
+		}
+		p.Assert.MaxLatency = time.Duration(n) * time.Millisecond
+	}
+
+	return nil
+}
+
 func removeProbeHandler(c *gin.Context) {
 	uid := c.Param("uid")
 
@@ -123,8 +404,7 @@ func removeProbeHandler(c *gin.Context) {
 		return
 	}
 
-	gpm.RemoveProbe(probe)
-	gpm.FlushFile()
+	gpm.DeleteProbe(probe)
 
 	c.Redirect(302, "/")
 }
@@ -134,48 +414,164 @@ func Uuid() string {
 }
 
 type Probe struct {
-	Uid     string
-	URL     string
-	Method  string
-	Results []Result
+	Uid string
+	// Type is the checker used, see checkers. Empty means http.
+	Type string `json:",omitempty"`
+	// URL is the target of the check: a URL for http, host:port for tcp,
+	// tls and grpc, a name for dns.
+	URL    string
+	Method string
+
+	// Name and Group are how the probe is shown on the status page.
+	Name  string `json:",omitempty"`
+	Group string `json:",omitempty"`
+
+	// Interval between two checks and Timeout of a single check, the
+	// defaults are used when zero.
+	Interval time.Duration `json:",omitempty"`
+	Timeout  time.Duration `json:",omitempty"`
+
+	Request HTTPRequest
+	Assert  Assertions
+	DNS     DNSOptions
+	TLS     TLSOptions
+	GRPC    GRPCOptions
+
+	// Results is only read from files written before results moved to the
+	// store, they are migrated on load.
+	Results []Result `json:",omitempty"`
+
+	// Alert overrides the default alert rule from the config file.
+	Alert *AlertRule `json:",omitempty"`
+
+	// Locations the probe is checked from, empty means the server and every
+	// agent.
+	Locations []string `json:",omitempty"`
+
+	// Managed probes come from the config file and are kept in sync with
+	// it. Archived probes are no longer checked, their results are kept.
+	Managed  bool `json:",omitempty"`
+	Archived bool `json:",omitempty"`
+
+	mu   sync.Mutex
+	last Result
 }
 
-func (p *Probe) Ping(save bool) Result {
-	start := time.Now()
+// Validate checks what would otherwise only fail when the probe runs.
+func (p *Probe) Validate() error {
+	if p.URL == "" {
+		return fmt.Errorf("missing target")
+	}
+	if _, err := checkerFor(p.Type); err != nil {
+		return err
+	}
+	if _, err := statusMatches(p.Assert.Status, 200); err != nil {
+		return err
+	}
+	if _, err := regexp.Compile(p.Assert.BodyRegex); err != nil {
+		return err
+	}
+	if p.Interval < 0 || p.Timeout < 0 {
+		return fmt.Errorf("negative interval or timeout")
+	}
+	return nil
+}
+
+func (p *Probe) RunsAt(location string) bool {
+	return len(p.Locations) == 0 || contains(p.Locations, location)
+}
+
+func (p *Probe) DisplayName() string {
+	return or(p.Name, p.URL)
+}
 
-	req, _ := http.NewRequest(p.Method, p.URL, nil)
-	httpc := http.Client{
-		Timeout: 5 * time.Second,
+func (p *Probe) interval() time.Duration {
+	if p.Interval > 0 {
+		return p.Interval
 	}
+	return defaultInterval
+}
+
+func (p *Probe) timeout() time.Duration {
+	if p.Timeout > 0 {
+		return p.Timeout
+	}
+	return defaultTimeout
+}
+
+// clone copies the definition and the last result of the probe.
+func (p *Probe) clone() *Probe {
+	data, _ := json.Marshal(p)
+
+	c := &Probe{}
+	_ = json.Unmarshal(data, c)
+	c.setLast(p.Last())
+
+	return c
+}
 
-	var status int
-	resp, err := httpc.Do(req)
+// Last returns the latest saved result, it is zero before the first check.
+func (p *Probe) Last() Result {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return p.last
+}
+
+func (p *Probe) setLast(r Result) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.last = r
+}
+
+func (p *Probe) Ping(save bool) Result {
+	var result Result
+
+	checker, err := checkerFor(p.Type)
 	if err != nil {
-		status = 500
-		log.Printf("Error probing %s: %s", p.URL, err)
+		result = Result{Timestamp: time.Now(), Error: err.Error()}
 	} else {
-		status = resp.StatusCode
+		result = checker.Check(p)
 	}
 
-	duration := time.Since(start)
-
-	result := Result{
-		Timestamp: time.Now(),
-		Status:    status,
-		Duration:  duration,
+	if result.Error != "" {
+		log.Printf("Error probing %s: %s", p.URL, result.Error)
 	}
 
 	if save {
-		p.Results = append(p.Results, result)
+		saveResult(p, LOCATION, result)
 	}
 
 	return result
 }
 
+// saveResult records a result checked from location, agents replace it to
+// report results to the server instead.
+var saveResult = recordResult
+
+func recordResult(p *Probe, location string, r Result) {
+	p.setLast(r)
+	if err := store.Insert(p.Uid, location, r); err != nil {
+		log.Printf("Error saving result of %s: %s", p.URL, err)
+	}
+	alerter.Observe(p, location, r)
+}
+
+// Result of a single check. Status is the HTTP status, 0 for other checks or
+// when no response was received. Error holds the failure reason: a network
+// error or the failed assertion. Warning is set on results that passed but
+// need attention, like a certificate about to expire.
 type Result struct {
 	Status    int
 	Timestamp time.Time
 	Duration  time.Duration
+	Error     string `json:",omitempty"`
+	Warning   string `json:",omitempty"`
+}
+
+func (r Result) Ok() bool {
+	return r.Error == ""
 }
 
 type ProbeMgr struct {
@@ -197,6 +593,17 @@ func (pm *ProbeMgr) RemoveProbe(probe *Probe) {
 	delete(pm.Probes, probe.Uid)
 }
 
+// DeleteProbe removes the probe with its stored results and alert state.
+func (pm *ProbeMgr) DeleteProbe(probe *Probe) {
+	pm.RemoveProbe(probe)
+	pm.FlushFile()
+
+	if err := store.Delete(probe.Uid); err != nil {
+		log.Printf("Error deleting results of %s: %s", probe.Uid, err)
+	}
+	alerter.Forget(probe.Uid)
+}
+
 func (pm *ProbeMgr) GetProbe(id string) *Probe {
 	pm.mu.Lock()
 	defer pm.mu.Unlock()
@@ -204,11 +611,31 @@ func (pm *ProbeMgr) GetProbe(id string) *Probe {
 	return pm.Probes[id]
 }
 
+// SetProbes replaces all probes, keeping the last result of the ones that
+// stay. Agents use it to apply the probes assigned by the server.
+func (pm *ProbeMgr) SetProbes(probes map[string]*Probe) {
+	pm.mu.Lock()
+	defer pm.mu.Unlock()
+
+	for uid, p := range probes {
+		if old, ok := pm.Probes[uid]; ok {
+			p.setLast(old.Last())
+		}
+	}
+	pm.Probes = probes
+}
+
+// GetProbes returns a copy of the probe map, safe to range over while probes
+// are added or removed.
 func (pm *ProbeMgr) GetProbes() map[string]*Probe {
 	pm.mu.Lock()
 	defer pm.mu.Unlock()
 
-	return pm.Probes
+	probes := make(map[string]*Probe, len(pm.Probes))
+	for uid, p := range pm.Probes {
+		probes[uid] = p
+	}
+	return probes
 }
 
 func (pm *ProbeMgr) InitFromFile() {
@@ -226,7 +653,38 @@ func (pm *ProbeMgr) InitFromFile() {
 	_ = json.Unmarshal(data, &probes)
 	pm.Probes = probes
 
+	migrated := false
+	for uid, probe := range probes {
+		for _, r := range probe.Results {
+			// failures used to be recorded as a plain 500
+			if r.Status >= 400 && r.Error == "" {
+				r.Error = fmt.Sprintf("unexpected status %d", r.Status)
+			}
+			if err := store.Insert(uid, "", r); err != nil {
+				log.Printf("Error migrating results of %s: %s", probe.URL, err)
+				break
+			}
+		}
+		if len(probe.Results) > 0 {
+			probe.Results = nil
+			migrated = true
+		}
+
+		if last, ok := store.Latest(uid); ok {
+			probe.setLast(last)
+		}
+	}
+
 	log.Printf("Loaded %d probes from %s", len(probes), defaultFile)
+
+	if migrated {
+		// old results are past the raw retention, roll them up before the
+		// next compaction drops them.
+		if err := store.rollup(time.Time{}, time.Now()); err != nil {
+			log.Printf("Error rolling up migrated results: %s", err)
+		}
+		pm.FlushFile()
+	}
 }
 
 func (pm *ProbeMgr) FlushFile() {
@@ -242,11 +700,3 @@ func (pm *ProbeMgr) FlushFile() {
 
 	log.Printf("Flushed %d probes to %s", len(pm.GetProbes()), defaultFile)
 }
-
-func (pm *ProbeMgr) CronProbe() {
-	probes := pm.GetProbes()
-
-	for _, probe := range probes {
-		probe.Ping(true)
-	}
-}
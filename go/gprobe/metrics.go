@@ -0,0 +1,81 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// metricsHandler writes the latest result of every probe in the Prometheus
+// text exposition format.
+func metricsHandler(c *gin.Context) {
+	probes := gpm.GetProbes()
+
+	uids := make([]string, 0, len(probes))
+	for uid, p := range probes {
+		if !p.Archived {
+			uids = append(uids, uid)
+		}
+	}
+	sort.Strings(uids)
+
+	c.Header("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	w := c.Writer
+
+	gauge(w, "probe_up", "Whether the last check of the probe succeeded.", probes, uids, func(p *Probe, r Result) (float64, bool) {
+		if r.Ok() {
+			return 1, true
+		}
+		return 0, true
+	})
+	gauge(w, "probe_duration_seconds", "Duration of the last check of the probe.", probes, uids, func(p *Probe, r Result) (float64, bool) {
+		return r.Duration.Seconds(), true
+	})
+	gauge(w, "probe_http_status_code", "HTTP status code of the last check, 0 when no response was received.", probes, uids, func(p *Probe, r Result) (float64, bool) {
+		return float64(r.Status), p.Type == "" || p.Type == "http"
+	})
+	gauge(w, "probe_state", "Alert state of the probe: 0 up, 1 degraded, 2 down.", probes, uids, func(p *Probe, r Result) (float64, bool) {
+		switch alerter.State(p.Uid) {
+		case StateDegraded:
+			return 1, true
+		case StateDown:
+			return 2, true
+		}
+		return 0, true
+	})
+	gauge(w, "probe_last_check_timestamp_seconds", "Time of the last check of the probe.", probes, uids, func(p *Probe, r Result) (float64, bool) {
+		return float64(r.Timestamp.UnixMilli()) / 1000, true
+	})
+}
+
+// gauge writes one metric family, probes without a result yet or for which
+// value returns false are skipped.
+func gauge(w io.Writer, name, help string, probes map[string]*Probe, uids []string, value func(*Probe, Result) (float64, bool)) {
+	fmt.Fprintf(w, "# HELP %s %s\n", name, help)
+	fmt.Fprintf(w, "# TYPE %s gauge\n", name)
+
+	for _, uid := range uids {
+		p := probes[uid]
+		r := p.Last()
+		if r.Timestamp.IsZero() {
+			continue
+		}
+
+		v, ok := value(p, r)
+		if !ok {
+			continue
+		}
+
+		fmt.Fprintf(w, "%s{uid=%s,probe=%s,type=%s,target=%s,group=%s} %g\n", name,
+			label(uid), label(p.DisplayName()), label(or(p.Type, "http")), label(p.URL), label(p.Group), v)
+	}
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func label(s string) string {
+	return `"` + labelEscaper.Replace(s) + `"`
+}
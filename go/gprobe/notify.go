@@ -0,0 +1,147 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/smtp"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// NotifierConfig is one notification channel from the config file, which
+// fields are used depends on Type.
+type NotifierConfig struct {
+	Type string `yaml:"type"`
+
+	// webhook and discord
+	URL string `yaml:"url"`
+
+	// smtp
+	Host     string   `yaml:"host"`
+	Port     int      `yaml:"port"`
+	Username string   `yaml:"username"`
+	Password string   `yaml:"password"`
+	From     string   `yaml:"from"`
+	To       []string `yaml:"to"`
+
+	// telegram
+	Token  string `yaml:"token"`
+	ChatID string `yaml:"chat_id"`
+}
+
+type Notifier interface {
+	Notify(e Event) error
+}
+
+func (nc NotifierConfig) Notifier() (Notifier, error) {
+	switch nc.Type {
+	case "webhook":
+		return webhookNotifier{url: nc.URL}, nil
+	case "discord":
+		return discordNotifier{url: nc.URL}, nil
+	case "telegram":
+		return telegramNotifier{token: nc.Token, chatID: nc.ChatID}, nil
+	case "smtp":
+		return smtpNotifier(nc), nil
+	default:
+		return nil, fmt.Errorf("unknown notifier type %q", nc.Type)
+	}
+}
+
+var notifyClient = http.Client{
+	Timeout: 10 * time.Second,
+}
+
+func notifyAll(e Event) {
+	for _, nc := range currentConfig().Notifiers {
+		n, err := nc.Notifier()
+		if err != nil {
+			log.Printf("Error creating notifier: %s", err)
+			continue
+		}
+
+		if err := n.Notify(e); err != nil {
+			log.Printf("Error sending %s notification: %s", nc.Type, err)
+		}
+	}
+}
+
+func postJSON(u string, v any) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	resp, err := notifyClient.Post(u, "application/json", bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("unexpected status %s", resp.Status)
+	}
+
+	return nil
+}
+
+// webhookNotifier posts the event as JSON.
+type webhookNotifier struct {
+	url string
+}
+
+func (n webhookNotifier) Notify(e Event) error {
+	return postJSON(n.url, e)
+}
+
+type discordNotifier struct {
+	url string
+}
+
+func (n discordNotifier) Notify(e Event) error {
+	return postJSON(n.url, map[string]string{
+		"content": e.Text(),
+	})
+}
+
+type telegramNotifier struct {
+	token  string
+	chatID string
+}
+
+func (n telegramNotifier) Notify(e Event) error {
+	resp, err := notifyClient.PostForm("https://api.telegram.org/bot"+n.token+"/sendMessage", url.Values{
+		"chat_id": {n.chatID},
+		"text":    {e.Text()},
+	})
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("unexpected status %s", resp.Status)
+	}
+
+	return nil
+}
+
+type smtpNotifier NotifierConfig
+
+func (n smtpNotifier) Notify(e Event) error {
+	var auth smtp.Auth
+	if n.Username != "" {
+		auth = smtp.PlainAuth("", n.Username, n.Password, n.Host)
+	}
+
+	msg := "From: " + n.From + "\r\n" +
+		"To: " + strings.Join(n.To, ", ") + "\r\n" +
+		"Subject: " + e.Title() + "\r\n" +
+		"\r\n" + e.Text() + "\r\n"
+
+	return smtp.SendMail(fmt.Sprintf("%s:%d", n.Host, n.Port), auth, n.From, n.To, []byte(msg))
+}
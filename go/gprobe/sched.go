@@ -0,0 +1,103 @@
+package main
+
+import (
+	"log"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+const defaultInterval = 30 * time.Second
+
+// Scheduler runs every probe on its own interval on a fixed number of
+// workers. Runs are spread with jitter so probes added at the same time do
+// not fire together, and a probe is never queued again while still running.
+type Scheduler struct {
+	workers int
+	jobs    chan *Probe
+
+	mu      sync.Mutex
+	next    map[string]time.Time
+	running map[string]bool
+}
+
+func NewScheduler(workers int) *Scheduler {
+	return &Scheduler{
+		workers: workers,
+		jobs:    make(chan *Probe, workers),
+		next:    make(map[string]time.Time),
+		running: make(map[string]bool),
+	}
+}
+
+func (s *Scheduler) Start() {
+	for i := 0; i < s.workers; i++ {
+		go s.work()
+	}
+
+	go func() {
+		for now := range time.Tick(time.Second) {
+			s.tick(now)
+		}
+	}()
+}
+
+func (s *Scheduler) work() {
+	for p := range s.jobs {
+		p.Ping(true)
+
+		s.mu.Lock()
+		delete(s.running, p.Uid)
+		s.mu.Unlock()
+	}
+}
+
+func (s *Scheduler) tick(now time.Time) {
+	probes := gpm.GetProbes()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for uid, p := range probes {
+		if p.Archived || !p.RunsAt(LOCATION) {
+			delete(probes, uid)
+		}
+	}
+
+	for uid := range s.next {
+		if _, ok := probes[uid]; !ok {
+			delete(s.next, uid)
+		}
+	}
+
+	for uid, p := range probes {
+		interval := p.interval()
+
+		next, ok := s.next[uid]
+		if !ok {
+			// first sight, start somewhere within the first interval
+			s.next[uid] = now.Add(time.Duration(rand.Int63n(int64(interval))))
+			continue
+		}
+		if now.Before(next) || s.running[uid] {
+			continue
+		}
+
+		select {
+		case s.jobs <- p:
+			s.running[uid] = true
+			s.next[uid] = now.Add(jitter(interval))
+		default:
+			log.Printf("All %d workers busy, delaying %s", s.workers, p.URL)
+		}
+	}
+}
+
+// jitter returns d shifted by up to 10% either way.
+func jitter(d time.Duration) time.Duration {
+	j := int64(d) / 10
+	if j == 0 {
+		return d
+	}
+	return d + time.Duration(rand.Int63n(2*j)-j)
+}
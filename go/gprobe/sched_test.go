@@ -0,0 +1,186 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+// countingChecker counts the checks per probe, and notices probes checked
+// twice at the same time and more checks at once than workers.
+type countingChecker struct {
+	delay time.Duration
+
+	mu      sync.Mutex
+	runs    map[string]int
+	running map[string]bool
+	busy    int
+	maxBusy int
+	overlap []string
+}
+
+func (c *countingChecker) Check(p *Probe) Result {
+	c.mu.Lock()
+	if c.running[p.Uid] {
+		c.overlap = append(c.overlap, p.Uid)
+	}
+	c.running[p.Uid] = true
+	c.runs[p.Uid]++
+	c.busy++
+	if c.busy > c.maxBusy {
+		c.maxBusy = c.busy
+	}
+	c.mu.Unlock()
+
+	time.Sleep(c.delay)
+
+	c.mu.Lock()
+	c.running[p.Uid] = false
+	c.busy--
+	c.mu.Unlock()
+
+	return Result{Timestamp: time.Now(), Status: 200}
+}
+
+// testScheduler runs the workers of a scheduler over probes checked by c,
+// the returned func stops them.
+func testScheduler(t *testing.T, workers int, c *countingChecker, probes map[string]*Probe) (*Scheduler, func()) {
+	c.runs = make(map[string]int)
+	c.running = make(map[string]bool)
+	checkers["counting"] = c
+
+	savedProbes, savedSave := gpm.GetProbes(), saveResult
+	gpm.SetProbes(probes)
+	saveResult = func(p *Probe, location string, r Result) {
+		p.setLast(r)
+	}
+	t.Cleanup(func() {
+		delete(checkers, "counting")
+		gpm.SetProbes(savedProbes)
+		saveResult = savedSave
+	})
+
+	s := NewScheduler(workers)
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			s.work()
+		}()
+	}
+
+	return s, func() {
+		close(s.jobs)
+		wg.Wait()
+	}
+}
+
+// idle waits for the queued checks to be done.
+func (s *Scheduler) idle() {
+	for {
+		s.mu.Lock()
+		n := len(s.running)
+		s.mu.Unlock()
+		if n == 0 {
+			return
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestSchedulerIntervals(t *testing.T) {
+	c := &countingChecker{}
+	s, stop := testScheduler(t, 2, c, map[string]*Probe{
+		"fast":     {Uid: "fast", Type: "counting", Interval: 10 * time.Second},
+		"default":  {Uid: "default", Type: "counting"},
+		"archived": {Uid: "archived", Type: "counting", Interval: 10 * time.Second, Archived: true},
+		"remote":   {Uid: "remote", Type: "counting", Interval: 10 * time.Second, Locations: []string{"elsewhere"}},
+	})
+
+	for now := t0; now.Before(t0.Add(5 * time.Minute)); now = now.Add(time.Second) {
+		s.tick(now)
+		s.idle()
+	}
+	stop()
+
+	// the first run is within the first interval, then every interval
+	// give or take 10%
+	for uid, want := range map[string][2]int{
+		"fast":     {25, 34},
+		"default":  {8, 12},
+		"archived": {0, 0},
+		"remote":   {0, 0},
+	} {
+		if n := c.runs[uid]; n < want[0] || n > want[1] {
+			t.Errorf("%s ran %d times in 5 minutes, want %d to %d", uid, n, want[0], want[1])
+		}
+	}
+}
+
+// TestSchedulerRace runs checks slower than the ticks, with probes added and
+// removed meanwhile, best with -race.
+func TestSchedulerRace(t *testing.T) {
+	const workers = 4
+
+	probes := make(map[string]*Probe)
+	for i := 0; i < 20; i++ {
+		uid := fmt.Sprintf("p%d", i)
+		probes[uid] = &Probe{Uid: uid, Type: "counting", Interval: 2 * time.Second}
+	}
+	c := &countingChecker{delay: 5 * time.Millisecond}
+	s, stop := testScheduler(t, workers, c, probes)
+
+	done := make(chan struct{})
+	churned := make(chan struct{})
+	go func() {
+		defer close(churned)
+		for i := 0; ; i++ {
+			select {
+			case <-done:
+				return
+			default:
+			}
+
+			p := &Probe{Uid: fmt.Sprintf("churn%d", i%5), Type: "counting", Interval: time.Second}
+			gpm.AddProbe(p)
+			for _, p := range gpm.GetProbes() {
+				p.Last()
+			}
+			time.Sleep(time.Millisecond)
+			gpm.RemoveProbe(p)
+		}
+	}()
+
+	now := t0
+	for i := 0; i < 200; i++ {
+		s.tick(now)
+		now = now.Add(time.Second)
+		time.Sleep(time.Millisecond)
+	}
+	close(done)
+	<-churned
+
+	// the last tick forgets the removed probes
+	s.tick(now)
+	s.idle()
+	stop()
+
+	if len(c.overlap) > 0 {
+		t.Errorf("probes checked while still running: %v", c.overlap)
+	}
+	if c.maxBusy > workers {
+		t.Errorf("%d checks at once on %d workers", c.maxBusy, workers)
+	}
+	for uid := range probes {
+		if c.runs[uid] == 0 {
+			t.Errorf("%s never ran", uid)
+		}
+	}
+	for uid := range s.next {
+		if _, ok := probes[uid]; !ok {
+			t.Errorf("removed probe %s is still scheduled", uid)
+		}
+	}
+}
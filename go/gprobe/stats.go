@@ -0,0 +1,247 @@
+package main
+
+import (
+	"sort"
+	"strings"
+	"time"
+)
+
+var defaultWindows = []string{"24h", "7d", "30d"}
+
+// Stats summarizes a probe over a window of its stored results.
+type Stats struct {
+	Window    string        `json:"window"`
+	Checks    int           `json:"checks"`
+	Failures  int           `json:"failures"`
+	Uptime    float64       `json:"uptime"`
+	ErrorRate float64       `json:"error_rate"`
+	P50       time.Duration `json:"p50"`
+	P95       time.Duration `json:"p95"`
+	P99       time.Duration `json:"p99"`
+	Incidents int           `json:"incidents"`
+	MTTR      time.Duration `json:"mttr"`
+}
+
+// ProbeStats computes the stats of a probe for every window, windows use the
+// same syntax as parseWindow. Results during maintenance are left out.
+func ProbeStats(p *Probe, windows []string) ([]Stats, error) {
+	now := time.Now()
+	rule := p.alertRule()
+
+	var stats []Stats
+	for _, w := range windows {
+		d, err := parseWindow(w)
+		if err != nil {
+			return nil, err
+		}
+
+		points, err := store.Range(p.Uid, p.interval(), now.Add(-d), now)
+		if err != nil {
+			return nil, err
+		}
+
+		ms, err := store.Maintenances(now.Add(-d))
+		if err != nil {
+			return nil, err
+		}
+		points = withoutMaintenance(points, p.Uid, ms)
+
+		s := computeStats(points, maintenancesOf(p.Uid, ms), rule, now)
+		s.Window = w
+		stats = append(stats, s)
+	}
+
+	return stats, nil
+}
+
+func parseWindows(s string) []string {
+	if s == "" {
+		return defaultWindows
+	}
+
+	return strings.Split(s, ",")
+}
+
+// computeStats works on raw and rolled up points alike, with the points
+// during maintenance already left out. Uptime is time based: a down point
+// starts an incident that lasts until the next healthy point, the start of a
+// maintenance window, or now if it is still ongoing. The time windows cover
+// is not observed. Percentiles are exact over raw points, once rollups are
+// involved they are estimated from the histogram buckets.
+func computeStats(points []Point, windows []Maintenance, rule AlertRule, now time.Time) Stats {
+	var (
+		s         Stats
+		durations []time.Duration
+		hist      = make([]int, len(histBounds)+1)
+		rolled    bool
+		downtime  time.Duration
+		recovered time.Duration
+		downSince time.Time
+	)
+
+	if len(points) == 0 {
+		return s
+	}
+
+	for _, p := range points {
+		s.Checks += p.Count
+		s.Failures += p.Failures
+
+		if p.Hist != nil {
+			rolled = true
+			for i := 0; i < len(hist) && i < len(p.Hist); i++ {
+				hist[i] += p.Hist[i]
+			}
+		} else {
+			durations = append(durations, p.Avg)
+		}
+
+		if start, ok := windowStart(windows, downSince, p.Timestamp); ok {
+			recovered += start.Sub(downSince)
+			downSince = time.Time{}
+		}
+
+		down := p.down(rule)
+		if down && downSince.IsZero() {
+			downSince = p.Timestamp
+			s.Incidents++
+		} else if !down && !downSince.IsZero() {
+			recovered += p.Timestamp.Sub(downSince)
+			downSince = time.Time{}
+		}
+	}
+
+	resolved := s.Incidents
+	if start, ok := windowStart(windows, downSince, now); ok {
+		recovered += start.Sub(downSince)
+	} else if !downSince.IsZero() {
+		downtime = now.Sub(downSince)
+		resolved--
+	}
+	downtime += recovered
+	if resolved > 0 {
+		s.MTTR = recovered / time.Duration(resolved)
+	}
+
+	observed := now.Sub(points[0].Timestamp) - covered(windows, points[0].Timestamp, now)
+	if observed > 0 {
+		s.Uptime = 100 * (1 - float64(downtime)/float64(observed))
+	}
+	if s.Checks > 0 {
+		s.ErrorRate = 100 * float64(s.Failures) / float64(s.Checks)
+	}
+
+	if rolled {
+		for _, d := range durations {
+			hist[histBucket(d)]++
+		}
+		s.P50 = histPercentile(hist, 50)
+		s.P95 = histPercentile(hist, 95)
+		s.P99 = histPercentile(hist, 99)
+		return s
+	}
+
+	sort.Slice(durations, func(i, j int) bool {
+		return durations[i] < durations[j]
+	})
+	s.P50 = percentile(durations, 50)
+	s.P95 = percentile(durations, 95)
+	s.P99 = percentile(durations, 99)
+
+	return s
+}
+
+// windowStart returns the earliest start of a window within [from, to), if
+// an incident is open since from.
+func windowStart(windows []Maintenance, from, to time.Time) (time.Time, bool) {
+	var start time.Time
+	if from.IsZero() {
+		return start, false
+	}
+
+	for _, m := range windows {
+		if !m.Start.Before(from) && m.Start.Before(to) && (start.IsZero() || m.Start.Before(start)) {
+			start = m.Start
+		}
+	}
+	return start, !start.IsZero()
+}
+
+// covered returns how much of [from, to) the windows cover, overlapping
+// windows counted once.
+func covered(windows []Maintenance, from, to time.Time) time.Duration {
+	sorted := append([]Maintenance(nil), windows...)
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].Start.Before(sorted[j].Start)
+	})
+
+	var total time.Duration
+	for _, m := range sorted {
+		start, end := m.Start, m.End
+		if start.Before(from) {
+			start = from
+		}
+		if end.After(to) {
+			end = to
+		}
+		if end.After(start) {
+			total += end.Sub(start)
+			from = end
+		}
+	}
+	return total
+}
+
+// down tells whether a quorum of the locations of p failed most of their
+// checks, points from before locations by whether most checks failed.
+func (p Point) down(rule AlertRule) bool {
+	if p.Locations == 0 {
+		return p.Failures*2 > p.Count
+	}
+	return p.DownLocations >= rule.quorum(p.Locations)
+}
+
+// percentile uses the nearest-rank method on sorted durations.
+func percentile(sorted []time.Duration, p int) time.Duration {
+	if len(sorted) == 0 {
+		return 0
+	}
+
+	return sorted[rank(len(sorted), p)-1]
+}
+
+// histPercentile returns the upper bound of the bucket holding the
+// percentile, the overflow bucket reports the last bound.
+func histPercentile(hist []int, p int) time.Duration {
+	total := 0
+	for _, c := range hist {
+		total += c
+	}
+	if total == 0 {
+		return 0
+	}
+
+	want, seen := rank(total, p), 0
+	for i, c := range hist {
+		seen += c
+		if seen >= want && i < len(histBounds) {
+			return histBounds[i]
+		}
+	}
+
+	return histBounds[len(histBounds)-1]
+}
+
+func histBucket(d time.Duration) int {
+	return sort.Search(len(histBounds), func(i int) bool {
+		return d < histBounds[i]
+	})
+}
+
+func rank(n, p int) int {
+	r := (p*n + 99) / 100
+	if r < 1 {
+		r = 1
+	}
+	return r
+}
@@ -0,0 +1,253 @@
+package main
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+var t0 = time.Date(2024, 5, 1, 10, 0, 0, 0, time.Local)
+
+func at(d time.Duration) time.Time {
+	return t0.Add(d)
+}
+
+// raw is a single check at t0+d.
+func raw(d time.Duration, ok bool, avg time.Duration) Point {
+	p := Point{Timestamp: at(d), Count: 1, Avg: avg, Min: avg, Max: avg}
+	if !ok {
+		p.Failures = 1
+	}
+	return p
+}
+
+func window(from, to time.Duration, probes ...string) Maintenance {
+	return Maintenance{Title: "maintenance", Probes: probes, Start: at(from), End: at(to)}
+}
+
+func TestComputeStatsMaintenance(t *testing.T) {
+	tests := []struct {
+		name          string
+		points        []Point
+		windows       []Maintenance
+		now           time.Duration
+		wantIncidents int
+		wantMTTR      time.Duration
+		wantUptime    float64
+	}{
+		{
+			name: "incident ends when the window starts",
+			// down at 10:00, maintenance 10:30-12:00, up at 12:00
+			points:        []Point{raw(0, false, 0), raw(2*time.Hour, true, 0)},
+			windows:       []Maintenance{window(30*time.Minute, 2*time.Hour)},
+			now:           3 * time.Hour,
+			wantIncidents: 1,
+			wantMTTR:      30 * time.Minute,
+			// 30m down out of 1h30 observed
+			wantUptime: 100 * (1 - 30.0/90),
+		},
+		{
+			name:          "without the window",
+			points:        []Point{raw(0, false, 0), raw(2*time.Hour, true, 0)},
+			now:           3 * time.Hour,
+			wantIncidents: 1,
+			wantMTTR:      2 * time.Hour,
+			wantUptime:    100 * (1 - 2.0/3),
+		},
+		{
+			name:          "ongoing into a window",
+			points:        []Point{raw(0, false, 0)},
+			windows:       []Maintenance{window(30*time.Minute, 5*time.Hour)},
+			now:           3 * time.Hour,
+			wantIncidents: 1,
+			wantMTTR:      30 * time.Minute,
+			wantUptime:    0,
+		},
+		{
+			name:          "down again after the window is a new incident",
+			points:        []Point{raw(0, false, 0), raw(2*time.Hour, false, 0), raw(150*time.Minute, true, 0)},
+			windows:       []Maintenance{window(30*time.Minute, 2*time.Hour)},
+			now:           3 * time.Hour,
+			wantIncidents: 2,
+			wantMTTR:      30 * time.Minute,
+			wantUptime:    100 * (1 - 60.0/90),
+		},
+		{
+			name:   "overlapping windows count once",
+			points: []Point{raw(0, true, 0), raw(2*time.Hour, true, 0)},
+			windows: []Maintenance{
+				window(30*time.Minute, 90*time.Minute),
+				window(time.Hour, 2*time.Hour),
+			},
+			now:        3 * time.Hour,
+			wantUptime: 100,
+		},
+		{
+			name:          "windows before the first point are not observed anyway",
+			points:        []Point{raw(0, false, 0), raw(time.Hour, true, 0)},
+			windows:       []Maintenance{window(-2*time.Hour, -time.Hour)},
+			now:           2 * time.Hour,
+			wantIncidents: 1,
+			wantMTTR:      time.Hour,
+			wantUptime:    50,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := computeStats(tt.points, tt.windows, defaultAlertRule, at(tt.now))
+			if s.Incidents != tt.wantIncidents || s.MTTR != tt.wantMTTR || math.Abs(s.Uptime-tt.wantUptime) > 1e-9 {
+				t.Errorf("got %d incidents, MTTR %s, uptime %.4f, want %d, %s, %.4f",
+					s.Incidents, s.MTTR, s.Uptime, tt.wantIncidents, tt.wantMTTR, tt.wantUptime)
+			}
+		})
+	}
+}
+
+func TestMaintenancesOf(t *testing.T) {
+	ms := []Maintenance{window(0, time.Hour), window(0, time.Hour, "a"), window(0, time.Hour, "b", "c")}
+
+	if got := maintenancesOf("a", ms); len(got) != 2 {
+		t.Errorf("maintenancesOf(a) = %d windows, want the one for all and the one for a", len(got))
+	}
+	if got := maintenancesOf("d", ms); len(got) != 1 {
+		t.Errorf("maintenancesOf(d) = %d windows, want the one for all", len(got))
+	}
+}
+
+func TestComputeStats(t *testing.T) {
+	var ramp []Point
+	for i := 1; i <= 100; i++ {
+		ramp = append(ramp, raw(time.Duration(i)*time.Second, i%10 != 0, time.Duration(i)*time.Millisecond))
+	}
+
+	rollup := func(d time.Duration, count, failures int, h []int) Point {
+		return Point{Timestamp: at(d), Count: count, Failures: failures, Hist: h}
+	}
+	located := func(d time.Duration, locations, down int) Point {
+		return Point{Timestamp: at(d), Count: locations, Failures: down, Locations: locations, DownLocations: down}
+	}
+
+	tests := []struct {
+		name   string
+		points []Point
+		now    time.Duration
+		want   Stats
+	}{
+		{
+			name: "no points",
+			now:  time.Hour,
+		},
+		{
+			// every tenth check fails for a second, the last one is ongoing
+			name:   "exact percentiles over raw points",
+			points: ramp,
+			now:    100 * time.Second,
+			want: Stats{
+				Checks: 100, Failures: 10, ErrorRate: 10,
+				Uptime: 100 * (1 - 9.0/99),
+				P50:    50 * time.Millisecond, P95: 95 * time.Millisecond, P99: 99 * time.Millisecond,
+				Incidents: 10, MTTR: time.Second,
+			},
+		},
+		{
+			name: "percentiles from rollup histograms",
+			points: []Point{
+				rollup(0, 10, 0, hist(3*time.Millisecond, 3*time.Millisecond, 3*time.Millisecond, 3*time.Millisecond, 3*time.Millisecond,
+					20*time.Millisecond, 20*time.Millisecond, 20*time.Millisecond, 20*time.Millisecond, 2*time.Second)),
+				// a raw tail after the rollups goes into the histogram
+				raw(5*time.Minute, true, 40*time.Millisecond),
+			},
+			now: 10 * time.Minute,
+			want: Stats{
+				Checks: 11, Uptime: 100,
+				P50: 25 * time.Millisecond, P95: 2500 * time.Millisecond, P99: 2500 * time.Millisecond,
+			},
+		},
+		{
+			name: "mttr of resolved incidents, the ongoing one is downtime",
+			points: []Point{
+				raw(0, true, 0),
+				raw(time.Minute, false, 0), raw(2*time.Minute, true, 0),
+				raw(3*time.Minute, false, 0), raw(4*time.Minute, false, 0), raw(6*time.Minute, true, 0),
+				raw(8*time.Minute, false, 0),
+			},
+			now: 10 * time.Minute,
+			want: Stats{
+				Checks: 7, Failures: 4, ErrorRate: 100 * 4.0 / 7,
+				Uptime:    100 * (1 - 6.0/10),
+				Incidents: 3, MTTR: 2 * time.Minute,
+			},
+		},
+		{
+			name: "a point is down once a quorum of its locations is",
+			points: []Point{
+				located(0, 3, 1), located(time.Minute, 3, 2), located(2*time.Minute, 3, 1),
+				located(3*time.Minute, 1, 1), located(4*time.Minute, 2, 0),
+			},
+			now: 5 * time.Minute,
+			want: Stats{
+				Checks: 12, Failures: 5, ErrorRate: 100 * 5.0 / 12,
+				Uptime:    100 * (1 - 2.0/5),
+				Incidents: 2, MTTR: time.Minute,
+			},
+		},
+		{
+			name: "rollups from before locations are down when most checks failed",
+			points: []Point{
+				rollup(0, 4, 2, nil), rollup(time.Hour, 4, 3, nil), rollup(2*time.Hour, 4, 0, nil),
+			},
+			now: 4 * time.Hour,
+			want: Stats{
+				Checks: 12, Failures: 5, ErrorRate: 100 * 5.0 / 12,
+				Uptime:    75,
+				Incidents: 1, MTTR: time.Hour,
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := computeStats(tt.points, nil, defaultAlertRule, at(tt.now))
+			if math.Abs(got.Uptime-tt.want.Uptime) < 1e-9 {
+				got.Uptime = tt.want.Uptime
+			}
+			if math.Abs(got.ErrorRate-tt.want.ErrorRate) < 1e-9 {
+				got.ErrorRate = tt.want.ErrorRate
+			}
+			if got != tt.want {
+				t.Errorf("computeStats:\n got %+v\nwant %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPercentile(t *testing.T) {
+	sorted := []time.Duration{1, 2, 3, 4}
+
+	for p, want := range map[int]time.Duration{0: 1, 25: 1, 50: 2, 51: 3, 99: 4, 100: 4} {
+		if got := percentile(sorted, p); got != want {
+			t.Errorf("percentile(%d) = %d, want %d", p, got, want)
+		}
+	}
+	if got := percentile(nil, 50); got != 0 {
+		t.Errorf("percentile of nothing = %d, want 0", got)
+	}
+}
+
+func TestHistPercentile(t *testing.T) {
+	h := hist(time.Millisecond, 7*time.Millisecond, 7*time.Millisecond, time.Minute)
+
+	for p, want := range map[int]time.Duration{
+		25: 5 * time.Millisecond,
+		50: 10 * time.Millisecond,
+		75: 10 * time.Millisecond,
+		// the overflow bucket reports the last bound
+		100: 10 * time.Second,
+	} {
+		if got := histPercentile(h, p); got != want {
+			t.Errorf("histPercentile(%d) = %s, want %s", p, got, want)
+		}
+	}
+	if got := histPercentile(hist(), 50); got != 0 {
+		t.Errorf("histPercentile of nothing = %s, want 0", got)
+	}
+}
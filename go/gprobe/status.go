@@ -0,0 +1,482 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const statusDays = 90
+
+var incidentStatuses = []string{"investigating", "identified", "monitoring", "resolved"}
+
+type Incident struct {
+	ID        int64
+	Title     string
+	Status    string
+	CreatedAt time.Time
+	UpdatedAt time.Time
+	Updates   []IncidentUpdate
+}
+
+type IncidentUpdate struct {
+	Timestamp time.Time
+	Status    string
+	Message   string
+}
+
+// Maintenance is a scheduled window during which alerts of its probes are
+// not sent and their results do not count against uptime. No probes means
+// all of them.
+type Maintenance struct {
+	ID     int64
+	Title  string
+	Probes []string
+	Start  time.Time
+	End    time.Time
+}
+
+func (m Maintenance) Covers(uid string, t time.Time) bool {
+	if t.Before(m.Start) || !t.Before(m.End) {
+		return false
+	}
+	return m.applies(uid)
+}
+
+// applies tells whether the window is for uid, windows without probes are
+// for all of them.
+func (m Maintenance) applies(uid string) bool {
+	return len(m.Probes) == 0 || contains(m.Probes, uid)
+}
+
+func (s *Store) CreateIncident(title, status, message string) error {
+	now := time.Now().UnixMilli()
+
+	r, err := s.db.Exec("INSERT INTO incidents (title, status, created_at, updated_at) VALUES (?, ?, ?, ?)",
+		title, status, now, now)
+	if err != nil {
+		return err
+	}
+
+	id, err := r.LastInsertId()
+	if err != nil {
+		return err
+	}
+
+	_, err = s.db.Exec("INSERT INTO incident_updates (incident, ts, status, message) VALUES (?, ?, ?, ?)",
+		id, now, status, message)
+	return err
+}
+
+var errNoIncident = errors.New("no such incident")
+
+func (s *Store) UpdateIncident(id int64, status, message string) error {
+	now := time.Now().UnixMilli()
+
+	r, err := s.db.Exec("UPDATE incidents SET status = ?, updated_at = ? WHERE id = ?", status, now, id)
+	if err != nil {
+		return err
+	}
+	if n, err := r.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return errNoIncident
+	}
+
+	_, err = s.db.Exec("INSERT INTO incident_updates (incident, ts, status, message) VALUES (?, ?, ?, ?)",
+		id, now, status, message)
+	return err
+}
+
+// Incidents returns the incidents updated since the given time, newest first.
+func (s *Store) Incidents(since time.Time) ([]Incident, error) {
+	rows, err := s.db.Query("SELECT id, title, status, created_at, updated_at FROM incidents WHERE updated_at >= ? ORDER BY created_at DESC",
+		since.UnixMilli())
+	if err != nil {
+		return nil, err
+	}
+
+	var incidents []Incident
+	for rows.Next() {
+		var (
+			in               Incident
+			created, updated int64
+		)
+		if err := rows.Scan(&in.ID, &in.Title, &in.Status, &created, &updated); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		in.CreatedAt = time.UnixMilli(created)
+		in.UpdatedAt = time.UnixMilli(updated)
+		incidents = append(incidents, in)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	for i := range incidents {
+		incidents[i].Updates, err = s.incidentUpdates(incidents[i].ID)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return incidents, nil
+}
+
+func (s *Store) incidentUpdates(id int64) ([]IncidentUpdate, error) {
+	rows, err := s.db.Query("SELECT ts, status, message FROM incident_updates WHERE incident = ? ORDER BY ts DESC", id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var updates []IncidentUpdate
+	for rows.Next() {
+		var (
+			u  IncidentUpdate
+			ts int64
+		)
+		if err := rows.Scan(&ts, &u.Status, &u.Message); err != nil {
+			return nil, err
+		}
+		u.Timestamp = time.UnixMilli(ts)
+		updates = append(updates, u)
+	}
+
+	return updates, rows.Err()
+}
+
+func (s *Store) AddMaintenance(m Maintenance) error {
+	_, err := s.db.Exec("INSERT INTO maintenance (title, probes, starts_at, ends_at) VALUES (?, ?, ?, ?)",
+		m.Title, strings.Join(m.Probes, ","), m.Start.UnixMilli(), m.End.UnixMilli())
+	return err
+}
+
+func (s *Store) DeleteMaintenance(id int64) error {
+	_, err := s.db.Exec("DELETE FROM maintenance WHERE id = ?", id)
+	return err
+}
+
+// Maintenances returns the windows ending after since, by start time.
+func (s *Store) Maintenances(since time.Time) ([]Maintenance, error) {
+	rows, err := s.db.Query("SELECT id, title, probes, starts_at, ends_at FROM maintenance WHERE ends_at > ? ORDER BY starts_at",
+		since.UnixMilli())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ms []Maintenance
+	for rows.Next() {
+		var (
+			m          Maintenance
+			probes     string
+			start, end int64
+		)
+		if err := rows.Scan(&m.ID, &m.Title, &probes, &start, &end); err != nil {
+			return nil, err
+		}
+		if probes != "" {
+			m.Probes = strings.Split(probes, ",")
+		}
+		m.Start = time.UnixMilli(start)
+		m.End = time.UnixMilli(end)
+		ms = append(ms, m)
+	}
+
+	return ms, rows.Err()
+}
+
+// InMaintenance reports whether uid is covered by a maintenance window at t.
+func (s *Store) InMaintenance(uid string, t time.Time) bool {
+	ms, err := s.Maintenances(t)
+	if err != nil {
+		log.Printf("Error querying maintenance: %s", err)
+		return false
+	}
+
+	for _, m := range ms {
+		if m.Covers(uid, t) {
+			return true
+		}
+	}
+	return false
+}
+
+// maintenancesOf returns the windows of ms that apply to uid.
+func maintenancesOf(uid string, ms []Maintenance) []Maintenance {
+	var out []Maintenance
+	for _, m := range ms {
+		if m.applies(uid) {
+			out = append(out, m)
+		}
+	}
+	return out
+}
+
+// withoutMaintenance drops the points of uid that fall into a window.
+func withoutMaintenance(points []Point, uid string, ms []Maintenance) []Point {
+	if len(ms) == 0 {
+		return points
+	}
+
+	kept := points[:0:0]
+	for _, p := range points {
+		covered := false
+		for _, m := range ms {
+			if m.Covers(uid, p.Timestamp) {
+				covered = true
+				break
+			}
+		}
+		if !covered {
+			kept = append(kept, p)
+		}
+	}
+
+	return kept
+}
+
+// Day is one bar of the status page uptime history. Uptime is -1 for days
+// without data.
+type Day struct {
+	Date   time.Time
+	Uptime float64
+}
+
+// dailyUptime returns the last days of p, oldest first, as the share of
+// checks per day that were not in a down point, so a single failing location
+// of several doesn't count.
+func dailyUptime(p *Probe, days int, ms []Maintenance) ([]Day, error) {
+	now := time.Now()
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+	from := today.AddDate(0, 0, -(days - 1))
+
+	points, err := store.Range(p.Uid, p.interval(), from, now)
+	if err != nil {
+		return nil, err
+	}
+	points = withoutMaintenance(points, p.Uid, ms)
+	rule := p.alertRule()
+
+	checks := make([]int, days)
+	failures := make([]int, days)
+	for _, pt := range points {
+		i := int(pt.Timestamp.Sub(from).Hours() / 24)
+		if i < 0 || i >= days {
+			continue
+		}
+		checks[i] += pt.Count
+		if pt.down(rule) {
+			failures[i] += pt.Count
+		}
+	}
+
+	result := make([]Day, days)
+	for i := range result {
+		result[i] = Day{Date: from.AddDate(0, 0, i), Uptime: -1}
+		if checks[i] > 0 {
+			result[i].Uptime = 100 * (1 - float64(failures[i])/float64(checks[i]))
+		}
+	}
+
+	return result, nil
+}
+
+type Component struct {
+	Name   string
+	State  State
+	Uptime float64
+	Days   []Day
+}
+
+type ComponentGroup struct {
+	Name       string
+	Components []Component
+}
+
+func statusPageHandler(c *gin.Context) {
+	now := time.Now()
+
+	ms, err := store.Maintenances(now.AddDate(0, 0, -statusDays))
+	if err != nil {
+		c.String(500, "Error querying maintenance: %s", err)
+		return
+	}
+
+	groups := make(map[string][]Component)
+	for uid, p := range gpm.GetProbes() {
+		if p.Archived {
+			continue
+		}
+
+		days, err := dailyUptime(p, statusDays, ms)
+		if err != nil {
+			c.String(500, "Error querying results: %s", err)
+			return
+		}
+
+		checked, sum := 0, 0.0
+		for _, d := range days {
+			if d.Uptime >= 0 {
+				checked++
+				sum += d.Uptime
+			}
+		}
+
+		comp := Component{
+			Name:   p.DisplayName(),
+			State:  alerter.State(uid),
+			Uptime: -1,
+			Days:   days,
+		}
+		if checked > 0 {
+			comp.Uptime = sum / float64(checked)
+		}
+		if store.InMaintenance(uid, now) {
+			comp.State = "maintenance"
+		}
+
+		group := or(p.Group, "Services")
+		groups[group] = append(groups[group], comp)
+	}
+
+	var sorted []ComponentGroup
+	for name, comps := range groups {
+		sort.Slice(comps, func(i, j int) bool {
+			return comps[i].Name < comps[j].Name
+		})
+		sorted = append(sorted, ComponentGroup{Name: name, Components: comps})
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].Name < sorted[j].Name
+	})
+
+	incidents, err := store.Incidents(now.AddDate(0, 0, -14))
+	if err != nil {
+		c.String(500, "Error querying incidents: %s", err)
+		return
+	}
+
+	var upcoming []Maintenance
+	for _, m := range ms {
+		if m.End.After(now) {
+			upcoming = append(upcoming, m)
+		}
+	}
+
+	c.HTML(200, "status.html", gin.H{
+		"Groups":      sorted,
+		"Incidents":   incidents,
+		"Maintenance": upcoming,
+	})
+}
+
+func incidentsPageHandler(c *gin.Context) {
+	incidents, err := store.Incidents(time.Time{})
+	if err != nil {
+		c.String(500, "Error querying incidents: %s", err)
+		return
+	}
+
+	ms, err := store.Maintenances(time.Now())
+	if err != nil {
+		c.String(500, "Error querying maintenance: %s", err)
+		return
+	}
+
+	c.HTML(200, "incidents.html", gin.H{
+		"Incidents":   incidents,
+		"Statuses":    incidentStatuses,
+		"Maintenance": ms,
+		"Probes":      gpm.GetProbes(),
+	})
+}
+
+func createIncidentHandler(c *gin.Context) {
+	title := c.PostForm("title")
+	status := c.PostForm("status")
+	if title == "" || !contains(incidentStatuses, status) {
+		c.String(400, "Invalid incident")
+		return
+	}
+
+	if err := store.CreateIncident(title, status, c.PostForm("message")); err != nil {
+		c.String(500, "Error creating incident: %s", err)
+		return
+	}
+
+	c.Redirect(302, "/incidents")
+}
+
+func updateIncidentHandler(c *gin.Context) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	status := c.PostForm("status")
+	if err != nil || !contains(incidentStatuses, status) {
+		c.String(400, "Invalid incident update")
+		return
+	}
+
+	err = store.UpdateIncident(id, status, c.PostForm("message"))
+	if errors.Is(err, errNoIncident) {
+		c.String(404, "Incident %d not found", id)
+		return
+	}
+	if err != nil {
+		c.String(500, "Error updating incident: %s", err)
+		return
+	}
+
+	c.Redirect(302, "/incidents")
+}
+
+// datetime-local inputs, in the server's time zone
+const formTimeLayout = "2006-01-02T15:04"
+
+func addMaintenanceHandler(c *gin.Context) {
+	start, err := time.ParseInLocation(formTimeLayout, c.PostForm("start"), time.Local)
+	if err != nil {
+		c.String(400, "Invalid start: %s", err)
+		return
+	}
+	end, err := time.ParseInLocation(formTimeLayout, c.PostForm("end"), time.Local)
+	if err != nil || !end.After(start) {
+		c.String(400, "Invalid end")
+		return
+	}
+
+	m := Maintenance{
+		Title:  c.PostForm("title"),
+		Probes: c.PostFormArray("probes"),
+		Start:  start,
+		End:    end,
+	}
+	if err := store.AddMaintenance(m); err != nil {
+		c.String(500, "Error adding maintenance: %s", err)
+		return
+	}
+
+	c.Redirect(302, "/incidents")
+}
+
+func deleteMaintenanceHandler(c *gin.Context) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.String(400, "Invalid maintenance ID")
+		return
+	}
+
+	if err := store.DeleteMaintenance(id); err != nil {
+		c.String(500, "Error deleting maintenance: %s", err)
+		return
+	}
+
+	c.Redirect(302, "/incidents")
+}
@@ -0,0 +1,513 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	_ "modernc.org/sqlite"
+)
+
+// Raw results are kept for a day, after that only the 5-minute and hourly
+// rollups are left to answer range queries.
+const (
+	rawRetention     = 24 * time.Hour
+	fiveMinRetention = 7 * 24 * time.Hour
+	hourlyRetention  = 400 * 24 * time.Hour
+)
+
+var rollupResolutions = []struct {
+	step      time.Duration
+	retention time.Duration
+}{
+	{5 * time.Minute, fiveMinRetention},
+	{time.Hour, hourlyRetention},
+}
+
+// storeSchema is applied in order, PRAGMA user_version records how many
+// statements an existing database has already seen. Only append to it.
+var storeSchema = []string{
+	`CREATE TABLE IF NOT EXISTS results (
+		probe TEXT NOT NULL,
+		ts INTEGER NOT NULL,
+		status INTEGER NOT NULL,
+		duration INTEGER NOT NULL
+	)`,
+	`CREATE INDEX IF NOT EXISTS results_probe_ts ON results (probe, ts)`,
+	`CREATE TABLE IF NOT EXISTS rollups (
+		probe TEXT NOT NULL,
+		step INTEGER NOT NULL,
+		ts INTEGER NOT NULL,
+		count INTEGER NOT NULL,
+		failures INTEGER NOT NULL,
+		duration_sum INTEGER NOT NULL,
+		duration_min INTEGER NOT NULL,
+		duration_max INTEGER NOT NULL,
+		PRIMARY KEY (probe, step, ts)
+	)`,
+	`ALTER TABLE rollups ADD COLUMN hist TEXT NOT NULL DEFAULT ''`,
+	`ALTER TABLE results ADD COLUMN error TEXT NOT NULL DEFAULT ''`,
+	`UPDATE results SET error = 'unexpected status ' || status WHERE status >= 400`,
+	`ALTER TABLE results ADD COLUMN warning TEXT NOT NULL DEFAULT ''`,
+	`CREATE TABLE IF NOT EXISTS incidents (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		title TEXT NOT NULL,
+		status TEXT NOT NULL,
+		created_at INTEGER NOT NULL,
+		updated_at INTEGER NOT NULL
+	)`,
+	`CREATE TABLE IF NOT EXISTS incident_updates (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		incident INTEGER NOT NULL,
+		ts INTEGER NOT NULL,
+		status TEXT NOT NULL,
+		message TEXT NOT NULL
+	)`,
+	`CREATE TABLE IF NOT EXISTS maintenance (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		title TEXT NOT NULL,
+		probes TEXT NOT NULL DEFAULT '',
+		starts_at INTEGER NOT NULL,
+		ends_at INTEGER NOT NULL
+	)`,
+	`ALTER TABLE results ADD COLUMN location TEXT NOT NULL DEFAULT ''`,
+	`ALTER TABLE rollups ADD COLUMN locations INTEGER NOT NULL DEFAULT 0`,
+	`ALTER TABLE rollups ADD COLUMN down_locations INTEGER NOT NULL DEFAULT 0`,
+}
+
+// histBounds are the upper bounds of the latency histogram kept in rollups,
+// the last bucket counts everything slower than the last bound.
+var histBounds = []time.Duration{
+	5 * time.Millisecond,
+	10 * time.Millisecond,
+	25 * time.Millisecond,
+	50 * time.Millisecond,
+	100 * time.Millisecond,
+	250 * time.Millisecond,
+	500 * time.Millisecond,
+	time.Second,
+	2500 * time.Millisecond,
+	5 * time.Second,
+	10 * time.Second,
+}
+
+// Point is one sample of a probe's time series. For raw data Count is 1 and
+// the duration fields all hold the single measured duration.
+type Point struct {
+	Timestamp  time.Time     `json:"timestamp"`
+	Count      int           `json:"count"`
+	Failures   int           `json:"failures"`
+	Avg        time.Duration `json:"avg"`
+	Min        time.Duration `json:"min"`
+	Max        time.Duration `json:"max"`
+	LastStatus int           `json:"status,omitempty"`
+	Error      string        `json:"error,omitempty"`
+
+	// Locations reported in the point, DownLocations of them failed most of
+	// their checks. Both are 0 for rollups from before locations.
+	Locations     int    `json:"locations,omitempty"`
+	DownLocations int    `json:"down_locations,omitempty"`
+	Location      string `json:"-"`
+
+	// Hist has one count per histBounds entry plus the overflow bucket, it is
+	// only set for rollups.
+	Hist []int `json:"-"`
+}
+
+type Store struct {
+	db *sql.DB
+}
+
+func OpenStore(path string) (*Store, error) {
+	db, err := sql.Open("sqlite", path)
+	if err != nil {
+		return nil, err
+	}
+
+	// sqlite only allows one writer, serialize everything through one conn
+	// instead of handling SQLITE_BUSY.
+	db.SetMaxOpenConns(1)
+
+	if err := migrate(db); err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return &Store{db: db}, nil
+}
+
+func migrate(db *sql.DB) error {
+	var version int
+	if err := db.QueryRow("PRAGMA user_version").Scan(&version); err != nil {
+		return err
+	}
+
+	for i := version; i < len(storeSchema); i++ {
+		if _, err := db.Exec(storeSchema[i]); err != nil {
+			return fmt.Errorf("migration %d: %w", i+1, err)
+		}
+		if _, err := db.Exec(fmt.Sprintf("PRAGMA user_version = %d", i+1)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (s *Store) Close() error {
+	return s.db.Close()
+}
+
+// Insert saves a result, location is where the check ran from.
+func (s *Store) Insert(uid, location string, r Result) error {
+	_, err := s.db.Exec("INSERT INTO results (probe, ts, status, duration, error, warning, location) VALUES (?, ?, ?, ?, ?, ?, ?)",
+		uid, r.Timestamp.UnixMilli(), r.Status, int64(r.Duration), r.Error, r.Warning, location)
+	return err
+}
+
+func (s *Store) Latest(uid string) (Result, bool) {
+	var (
+		r      Result
+		ts     int64
+		status int
+		dur    int64
+	)
+
+	err := s.db.QueryRow("SELECT ts, status, duration, error, warning FROM results WHERE probe = ? ORDER BY ts DESC LIMIT 1", uid).
+		Scan(&ts, &status, &dur, &r.Error, &r.Warning)
+	if err != nil {
+		return r, false
+	}
+
+	r.Timestamp = time.UnixMilli(ts)
+	r.Status = status
+	r.Duration = time.Duration(dur)
+
+	return r, true
+}
+
+// LatestByLocation returns the latest raw result of uid for every location
+// that reported within the raw retention.
+func (s *Store) LatestByLocation(uid string) (map[string]Result, error) {
+	rows, err := s.db.Query(`SELECT location, ts, status, duration, error, warning FROM results r
+		WHERE probe = ? AND ts = (SELECT MAX(ts) FROM results WHERE probe = r.probe AND location = r.location)`, uid)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	latest := make(map[string]Result)
+	for rows.Next() {
+		var (
+			r        Result
+			location string
+			ts, dur  int64
+		)
+		if err := rows.Scan(&location, &ts, &r.Status, &dur, &r.Error, &r.Warning); err != nil {
+			return nil, err
+		}
+		r.Timestamp = time.UnixMilli(ts)
+		r.Duration = time.Duration(dur)
+		latest[location] = r
+	}
+
+	return latest, rows.Err()
+}
+
+func (s *Store) Delete(uid string) error {
+	if _, err := s.db.Exec("DELETE FROM results WHERE probe = ?", uid); err != nil {
+		return err
+	}
+	_, err := s.db.Exec("DELETE FROM rollups WHERE probe = ?", uid)
+	return err
+}
+
+// Range returns the time series of a probe between from and to. The
+// resolution is picked from the age of from: raw results inside the raw
+// retention, otherwise the finest rollup that still covers the range. Raw
+// results newer than the last rollup are appended so the tail of the range
+// is not missing until the next compaction. Raw results of several locations
+// are merged into a point per interval, the probe interval.
+func (s *Store) Range(uid string, interval time.Duration, from, to time.Time) ([]Point, error) {
+	// compaction runs every 5 minutes, raw data is a bit older than the
+	// retention right before it.
+	age := time.Since(from)
+	if age <= rawRetention+5*time.Minute {
+		raw, err := s.rawRange(uid, from, to)
+		return mergeLocations(raw, interval), err
+	}
+
+	step := rollupResolutions[len(rollupResolutions)-1].step
+	for _, res := range rollupResolutions {
+		if age <= res.retention {
+			step = res.step
+			break
+		}
+	}
+
+	points, err := s.rollupRange(uid, step, from, to)
+	if err != nil {
+		return nil, err
+	}
+
+	tail := from
+	if len(points) > 0 {
+		tail = points[len(points)-1].Timestamp.Add(step)
+	}
+	raw, err := s.rawRange(uid, tail, to)
+	if err != nil {
+		return nil, err
+	}
+
+	return append(points, mergeLocations(raw, interval)...), nil
+}
+
+// mergeLocations merges raw points into one point per step once they come
+// from more than one location, a location is down in a step when most of
+// its checks failed.
+func mergeLocations(points []Point, step time.Duration) []Point {
+	single := true
+	for _, p := range points {
+		if p.Location != points[0].Location {
+			single = false
+			break
+		}
+	}
+	if single || step <= 0 {
+		return points
+	}
+
+	type counts struct{ checks, failures int }
+	var (
+		merged []Point
+		cur    *Point
+		sum    time.Duration
+		locs   map[string]*counts
+	)
+	flush := func() {
+		if cur == nil {
+			return
+		}
+		cur.Avg = sum / time.Duration(cur.Count)
+		cur.Locations = len(locs)
+		for _, c := range locs {
+			if c.failures*2 > c.checks {
+				cur.DownLocations++
+			}
+		}
+		merged = append(merged, *cur)
+	}
+
+	for _, p := range points {
+		ts := p.Timestamp.Truncate(step)
+		if cur == nil || !ts.Equal(cur.Timestamp) {
+			flush()
+			cur = &Point{Timestamp: ts, Min: p.Min, Max: p.Max}
+			sum = 0
+			locs = make(map[string]*counts)
+		}
+
+		cur.Count += p.Count
+		cur.Failures += p.Failures
+		sum += p.Avg * time.Duration(p.Count)
+		if p.Min < cur.Min {
+			cur.Min = p.Min
+		}
+		if p.Max > cur.Max {
+			cur.Max = p.Max
+		}
+		cur.LastStatus = p.LastStatus
+		cur.Error = p.Error
+
+		c := locs[p.Location]
+		if c == nil {
+			c = &counts{}
+			locs[p.Location] = c
+		}
+		c.checks += p.Count
+		c.failures += p.Failures
+	}
+	flush()
+
+	return merged
+}
+
+func (s *Store) rawRange(uid string, from, to time.Time) ([]Point, error) {
+	rows, err := s.db.Query("SELECT ts, status, duration, error, location FROM results WHERE probe = ? AND ts >= ? AND ts < ? ORDER BY ts",
+		uid, from.UnixMilli(), to.UnixMilli())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var points []Point
+	for rows.Next() {
+		var (
+			ts     int64
+			status int
+			dur    int64
+			errMsg string
+			loc    string
+		)
+		if err := rows.Scan(&ts, &status, &dur, &errMsg, &loc); err != nil {
+			return nil, err
+		}
+
+		p := Point{
+			Timestamp:  time.UnixMilli(ts),
+			Count:      1,
+			Avg:        time.Duration(dur),
+			Min:        time.Duration(dur),
+			Max:        time.Duration(dur),
+			LastStatus: status,
+			Error:      errMsg,
+			Locations:  1,
+			Location:   loc,
+		}
+		if errMsg != "" {
+			p.Failures = 1
+			p.DownLocations = 1
+		}
+
+		points = append(points, p)
+	}
+
+	return points, rows.Err()
+}
+
+func (s *Store) rollupRange(uid string, step time.Duration, from, to time.Time) ([]Point, error) {
+	rows, err := s.db.Query(`SELECT ts, count, failures, duration_sum, duration_min, duration_max, hist, locations, down_locations FROM rollups
+		WHERE probe = ? AND step = ? AND ts >= ? AND ts < ? ORDER BY ts`,
+		uid, step.Milliseconds(), from.Truncate(step).UnixMilli(), to.UnixMilli())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var points []Point
+	for rows.Next() {
+		var (
+			p                   Point
+			ts, sum, dmin, dmax int64
+			hist                string
+		)
+		if err := rows.Scan(&ts, &p.Count, &p.Failures, &sum, &dmin, &dmax, &hist, &p.Locations, &p.DownLocations); err != nil {
+			return nil, err
+		}
+
+		p.Hist = parseHist(hist)
+		p.Timestamp = time.UnixMilli(ts)
+		p.Min = time.Duration(dmin)
+		p.Max = time.Duration(dmax)
+		if p.Count > 0 {
+			p.Avg = time.Duration(sum / int64(p.Count))
+		}
+
+		points = append(points, p)
+	}
+
+	return points, rows.Err()
+}
+
+// Compact folds raw results into the rollup tables and drops everything past
+// its retention. Rollups are recomputed from raw data for every bucket still
+// covered by it, so running it repeatedly is harmless.
+func (s *Store) Compact() error {
+	now := time.Now()
+
+	// only buckets that are still fully backed by raw data, older ones would
+	// be overwritten with partial counts.
+	if err := s.rollup(now.Add(-rawRetention), now); err != nil {
+		return err
+	}
+
+	for _, res := range rollupResolutions {
+		if _, err := s.db.Exec("DELETE FROM rollups WHERE step = ? AND ts < ?",
+			res.step.Milliseconds(), now.Add(-res.retention).UnixMilli()); err != nil {
+			return err
+		}
+	}
+
+	r, err := s.db.Exec("DELETE FROM results WHERE ts < ?", now.Add(-rawRetention).UnixMilli())
+	if err != nil {
+		return err
+	}
+
+	if n, _ := r.RowsAffected(); n > 0 {
+		log.Printf("Compacted %d raw results older than %s", n, rawRetention)
+	}
+
+	return nil
+}
+
+// rollup recomputes every complete bucket between from and to from the raw
+// results. A location is down in a bucket when most of its checks failed.
+func (s *Store) rollup(from, to time.Time) error {
+	for _, res := range rollupResolutions {
+		stepMs := res.step.Milliseconds()
+		start := from.Truncate(res.step)
+		if start.Before(from) {
+			start = start.Add(res.step)
+		}
+		end := to.Truncate(res.step)
+
+		_, err := s.db.Exec(`INSERT OR REPLACE INTO rollups
+			(probe, step, ts, count, failures, duration_sum, duration_min, duration_max, hist, locations, down_locations)
+			SELECT r.probe, ?, r.bucket * ?, r.count, r.failures, r.sum, r.min, r.max, r.hist, l.locations, l.down
+			FROM (
+				SELECT probe, ts / ? AS bucket, COUNT(*) AS count,
+					SUM(CASE WHEN error != '' THEN 1 ELSE 0 END) AS failures,
+					SUM(duration) AS sum, MIN(duration) AS min, MAX(duration) AS max, `+histExpr+` AS hist
+				FROM results WHERE ts >= ? AND ts < ?
+				GROUP BY probe, bucket
+			) r JOIN (
+				SELECT probe, bucket, COUNT(*) AS locations,
+					SUM(CASE WHEN failures * 2 > count THEN 1 ELSE 0 END) AS down
+				FROM (
+					SELECT probe, ts / ? AS bucket, location, COUNT(*) AS count,
+						SUM(CASE WHEN error != '' THEN 1 ELSE 0 END) AS failures
+					FROM results WHERE ts >= ? AND ts < ?
+					GROUP BY probe, bucket, location
+				)
+				GROUP BY probe, bucket
+			) l ON l.probe = r.probe AND l.bucket = r.bucket`,
+			stepMs, stepMs,
+			stepMs, start.UnixMilli(), end.UnixMilli(),
+			stepMs, start.UnixMilli(), end.UnixMilli())
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// histExpr is the SQL aggregate building the comma separated hist column.
+var histExpr = func() string {
+	var cols []string
+	lo := int64(0)
+	for _, b := range histBounds {
+		cols = append(cols, fmt.Sprintf("SUM(CASE WHEN duration >= %d AND duration < %d THEN 1 ELSE 0 END)", lo, int64(b)))
+		lo = int64(b)
+	}
+	cols = append(cols, fmt.Sprintf("SUM(CASE WHEN duration >= %d THEN 1 ELSE 0 END)", lo))
+
+	return strings.Join(cols, " || ',' || ")
+}()
+
+func parseHist(s string) []int {
+	if s == "" {
+		return nil
+	}
+
+	parts := strings.Split(s, ",")
+	hist := make([]int, len(parts))
+	for i, p := range parts {
+		hist[i], _ = strconv.Atoi(p)
+	}
+
+	return hist
+}
@@ -0,0 +1,233 @@
+package main
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// testStore opens a store in a temporary directory and makes it the global
+// one for the test.
+func testStore(t *testing.T) *Store {
+	s, err := OpenStore(filepath.Join(t.TempDir(), "gprobe.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	saved := store
+	store = s
+	t.Cleanup(func() {
+		store = saved
+		s.Close()
+	})
+	return s
+}
+
+func insert(t *testing.T, s *Store, location string, ts time.Time, d time.Duration, ok bool) {
+	r := Result{Timestamp: ts, Duration: d, Status: 200}
+	if !ok {
+		r.Status, r.Error = 500, "unexpected status 500"
+	}
+	if err := s.Insert("p", location, r); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func hist(ds ...time.Duration) []int {
+	h := make([]int, len(histBounds)+1)
+	for _, d := range ds {
+		h[histBucket(d)]++
+	}
+	return h
+}
+
+func TestRollup(t *testing.T) {
+	s := testStore(t)
+
+	// a fails most of its checks in the first 5 minutes, but not over the hour
+	insert(t, s, "a", at(0), 3*time.Millisecond, false)
+	insert(t, s, "a", at(time.Minute), 20*time.Millisecond, false)
+	insert(t, s, "a", at(2*time.Minute), 300*time.Millisecond, true)
+	insert(t, s, "b", at(time.Minute), 7*time.Millisecond, true)
+	insert(t, s, "b", at(3*time.Minute), 7*time.Millisecond, true)
+	insert(t, s, "a", at(6*time.Minute), 3*time.Millisecond, true)
+	// outside of the rolled up hour
+	insert(t, s, "a", at(time.Hour), time.Millisecond, false)
+
+	// rolling up again recomputes the same buckets
+	for i := 0; i < 2; i++ {
+		if err := s.rollup(at(0), at(time.Hour)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	fiveMin, err := s.rollupRange("p", 5*time.Minute, at(0), at(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Point{
+		{
+			Timestamp: at(0), Count: 5, Failures: 2,
+			Avg: 337 * time.Millisecond / 5, Min: 3 * time.Millisecond, Max: 300 * time.Millisecond,
+			Locations: 2, DownLocations: 1,
+			Hist: hist(3*time.Millisecond, 20*time.Millisecond, 300*time.Millisecond, 7*time.Millisecond, 7*time.Millisecond),
+		},
+		{
+			Timestamp: at(5 * time.Minute), Count: 1,
+			Avg: 3 * time.Millisecond, Min: 3 * time.Millisecond, Max: 3 * time.Millisecond,
+			Locations: 1,
+			Hist:      hist(3 * time.Millisecond),
+		},
+	}
+	if !reflect.DeepEqual(fiveMin, want) {
+		t.Errorf("5-minute rollups:\n got %+v\nwant %+v", fiveMin, want)
+	}
+
+	hourly, err := s.rollupRange("p", time.Hour, at(0), at(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(hourly) != 1 {
+		t.Fatalf("got %d hourly rollups, want 1", len(hourly))
+	}
+	if h := hourly[0]; h.Count != 6 || h.Failures != 2 || h.Locations != 2 || h.DownLocations != 0 {
+		t.Errorf("hourly rollup: got %d checks, %d failures, %d of %d locations down, want 6, 2, 0 of 2",
+			h.Count, h.Failures, h.DownLocations, h.Locations)
+	}
+}
+
+func TestRange(t *testing.T) {
+	s := testStore(t)
+
+	interval := 30 * time.Second
+	now := time.Now()
+	step := now.Add(-10 * time.Minute).Truncate(interval)
+
+	// a check from every location in one interval, two of three failing
+	insert(t, s, "a", step.Add(time.Second), 10*time.Millisecond, false)
+	insert(t, s, "b", step.Add(2*time.Second), 20*time.Millisecond, true)
+	insert(t, s, "c", step.Add(3*time.Second), 30*time.Millisecond, false)
+	// and only one in the next
+	insert(t, s, "a", step.Add(interval+time.Second), 10*time.Millisecond, true)
+
+	points, err := s.Range("p", interval, now.Add(-time.Hour), now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Point{
+		{
+			Timestamp: step, Count: 3, Failures: 2,
+			Avg: 20 * time.Millisecond, Min: 10 * time.Millisecond, Max: 30 * time.Millisecond,
+			LastStatus: 500, Error: "unexpected status 500",
+			Locations: 3, DownLocations: 2,
+		},
+		{
+			Timestamp: step.Add(interval), Count: 1,
+			Avg: 10 * time.Millisecond, Min: 10 * time.Millisecond, Max: 10 * time.Millisecond,
+			LastStatus: 200, Locations: 1,
+		},
+	}
+	for i := range points {
+		points[i].Timestamp = points[i].Timestamp.Truncate(interval)
+	}
+	if !reflect.DeepEqual(points, want) {
+		t.Errorf("Range:\n got %+v\nwant %+v", points, want)
+	}
+}
+
+func TestRangeRollupsAndTail(t *testing.T) {
+	s := testStore(t)
+
+	now := time.Now()
+	insert(t, s, "", now.Add(-3*24*time.Hour), 10*time.Millisecond, true)
+	insert(t, s, "", now.Add(-2*24*time.Hour), 10*time.Millisecond, false)
+	if err := s.rollup(now.Add(-4*24*time.Hour), now); err != nil {
+		t.Fatal(err)
+	}
+	// newer than the last rollup
+	insert(t, s, "", now.Add(-time.Second), 10*time.Millisecond, true)
+
+	points, err := s.Range("p", 30*time.Second, now.Add(-4*24*time.Hour), now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(points) != 3 {
+		t.Fatalf("got %d points, want 2 rollups and the raw tail: %+v", len(points), points)
+	}
+	for i, p := range points[:2] {
+		if p.Hist == nil || p.Count != 1 || !p.Timestamp.Equal(p.Timestamp.Truncate(5*time.Minute)) {
+			t.Errorf("point %d is not a 5-minute rollup: %+v", i, p)
+		}
+	}
+	if !points[1].down(defaultAlertRule) {
+		t.Errorf("failed rollup is not down: %+v", points[1])
+	}
+	if tail := points[2]; tail.Hist != nil || tail.Count != 1 {
+		t.Errorf("tail is not the raw result: %+v", tail)
+	}
+}
+
+func TestMergeLocations(t *testing.T) {
+	point := func(loc string, d time.Duration, failed bool, ms time.Duration) Point {
+		p := raw(d, !failed, ms)
+		p.Location, p.Locations = loc, 1
+		if failed {
+			p.DownLocations = 1
+		}
+		return p
+	}
+	step := time.Minute
+
+	tests := []struct {
+		name   string
+		points []Point
+		step   time.Duration
+		want   []Point
+	}{
+		{
+			name:   "single location is left alone",
+			points: []Point{point("a", 0, true, 0), point("a", time.Second, false, 0)},
+			step:   step,
+			want:   []Point{point("a", 0, true, 0), point("a", time.Second, false, 0)},
+		},
+		{
+			name:   "no step is left alone",
+			points: []Point{point("a", 0, true, 0), point("b", 0, false, 0)},
+			want:   []Point{point("a", 0, true, 0), point("b", 0, false, 0)},
+		},
+		{
+			name: "one point per step",
+			points: []Point{
+				point("a", 0, true, 10*time.Millisecond),
+				point("b", time.Second, false, 30*time.Millisecond),
+				point("a", step, false, 10*time.Millisecond),
+				point("b", step+time.Second, false, 20*time.Millisecond),
+			},
+			step: step,
+			want: []Point{
+				{Timestamp: at(0), Count: 2, Failures: 1, Avg: 20 * time.Millisecond, Min: 10 * time.Millisecond, Max: 30 * time.Millisecond, Locations: 2, DownLocations: 1},
+				{Timestamp: at(step), Count: 2, Avg: 15 * time.Millisecond, Min: 10 * time.Millisecond, Max: 20 * time.Millisecond, Locations: 2},
+			},
+		},
+		{
+			name: "a location is down when most of its checks failed",
+			points: []Point{
+				point("a", 0, true, 0),
+				point("a", time.Second, false, 0),
+				point("b", 2*time.Second, true, 0),
+				point("b", 3*time.Second, true, 0),
+				point("b", 4*time.Second, false, 0),
+			},
+			step: step,
+			want: []Point{{Timestamp: at(0), Count: 5, Failures: 3, Locations: 2, DownLocations: 1}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := mergeLocations(tt.points, tt.step); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("mergeLocations:\n got %+v\nwant %+v", got, tt.want)
+			}
+		})
+	}
+}
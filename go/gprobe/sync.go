@@ -0,0 +1,136 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"regexp"
+	"time"
+)
+
+// ProbeSpec is a probe as written in the config file. Name is its stable
+// identity: it becomes the probe uid, so renaming a probe is a remove and an
+// add.
+type ProbeSpec struct {
+	Name      string        `yaml:"name"`
+	Title     string        `yaml:"title"`
+	Group     string        `yaml:"group"`
+	Type      string        `yaml:"type"`
+	Target    string        `yaml:"target"`
+	Method    string        `yaml:"method"`
+	Interval  time.Duration `yaml:"interval"`
+	Timeout   time.Duration `yaml:"timeout"`
+	Request   HTTPRequest   `yaml:"request"`
+	Expect    Assertions    `yaml:"expect"`
+	DNS       DNSOptions    `yaml:"dns"`
+	TLS       TLSOptions    `yaml:"tls"`
+	GRPC      GRPCOptions   `yaml:"grpc"`
+	Alert     *AlertRule    `yaml:"alert"`
+	Locations []string      `yaml:"locations"`
+}
+
+var specNameRe = regexp.MustCompile(`^[a-zA-Z0-9_.-]+$`)
+
+func (s ProbeSpec) Probe() (*Probe, error) {
+	if !specNameRe.MatchString(s.Name) {
+		return nil, fmt.Errorf("invalid probe name %q, only letters, digits, '_', '.' and '-'", s.Name)
+	}
+
+	method := s.Method
+	if method == "" && (s.Type == "" || s.Type == "http") {
+		method = "GET"
+	}
+
+	p := &Probe{
+		Uid:       s.Name,
+		Type:      s.Type,
+		URL:       s.Target,
+		Method:    method,
+		Name:      or(s.Title, s.Name),
+		Group:     s.Group,
+		Interval:  s.Interval,
+		Timeout:   s.Timeout,
+		Request:   s.Request,
+		Assert:    s.Expect,
+		DNS:       s.DNS,
+		TLS:       s.TLS,
+		GRPC:      s.GRPC,
+		Alert:     s.Alert,
+		Locations: s.Locations,
+		Managed:   true,
+	}
+
+	if err := p.Validate(); err != nil {
+		return nil, fmt.Errorf("probe %s: %w", s.Name, err)
+	}
+
+	return p, nil
+}
+
+// SyncProbes reconciles the probe list with the probes of the config:
+// missing ones are created, changed ones replaced keeping their results,
+// and managed probes no longer in the config are archived or deleted
+// depending on Prune. Probes added from the web form or the API are left
+// alone. An invalid spec aborts the sync so a typo can't archive probes.
+func SyncProbes(c Config) {
+	desired := make(map[string]*Probe)
+	for _, spec := range c.Probes {
+		p, err := spec.Probe()
+		if err != nil {
+			log.Printf("Error syncing probes: %s", err)
+			return
+		}
+		if _, dup := desired[p.Uid]; dup {
+			log.Printf("Error syncing probes: duplicate probe name %s", p.Uid)
+			return
+		}
+		desired[p.Uid] = p
+	}
+
+	var created, updated, archived, deleted int
+	for uid, p := range desired {
+		old := gpm.GetProbe(uid)
+		switch {
+		case old == nil:
+			created++
+		case sameDefinition(old, p):
+			continue
+		default:
+			p.setLast(old.Last())
+			updated++
+		}
+		gpm.AddProbe(p)
+	}
+
+	for uid, p := range gpm.GetProbes() {
+		if !p.Managed || desired[uid] != nil {
+			continue
+		}
+
+		if c.Prune == "delete" {
+			gpm.DeleteProbe(p)
+			deleted++
+			continue
+		}
+
+		if !p.Archived {
+			archivedProbe := p.clone()
+			archivedProbe.Archived = true
+			gpm.AddProbe(archivedProbe)
+			archived++
+		}
+	}
+
+	if created+updated+archived > 0 {
+		gpm.FlushFile()
+	}
+
+	log.Printf("Synced probes: %d created, %d updated, %d archived, %d deleted", created, updated, archived, deleted)
+}
+
+// sameDefinition compares the persisted fields of two probes.
+func sameDefinition(a, b *Probe) bool {
+	ja, _ := json.Marshal(a)
+	jb, _ := json.Marshal(b)
+	return string(ja) == string(jb)
+}
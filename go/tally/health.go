@@ -0,0 +1,653 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// fileHealth is what the health report needs of a file.
+type fileHealth struct {
+	path string
+	lang string
+	code int
+	// 1 + the branches of the code, 0 if the language has no branches
+	complexity int
+	funcs      []function
+
+	// hashes of the significant code lines and their line numbers
+	hashes []uint64
+	lines  []int
+}
+
+type function struct {
+	name  string
+	line  int
+	lines int
+}
+
+// notNames are keywords the function patterns of C-like languages take for
+// a return type or a name, as in `else if (` or `return f(`.
+var notNames = map[string]bool{
+	"if": true, "else": true, "for": true, "while": true, "switch": true, "catch": true,
+	"return": true, "new": true, "throw": true, "case": true, "delete": true, "goto": true,
+	"sizeof": true, "await": true, "yield": true,
+}
+
+// analyze estimates the complexity of a file, finds its functions and
+// hashes its code lines for the duplicates.
+func analyze(c Counter, path string, content []byte) fileHealth {
+	h := fileHealth{path: path, lang: c.lang}
+	branches := 0
+
+	// functions still open, innermost last
+	type open struct {
+		function
+		// brace depth or indentation the function ends at
+		depth  int
+		opened bool
+	}
+	var (
+		stack    []open
+		depth    int
+		lastCode int
+	)
+	done := func(f open, end int) {
+		f.lines = end - f.line + 1
+		h.funcs = append(h.funcs, f.function)
+	}
+
+	scanner := bufio.NewScanner(bytes.NewReader(content))
+	scanner.Buffer(nil, len(content)+1)
+
+	var st lineState
+	for n := 1; scanner.Scan(); n++ {
+		line := scanner.Bytes()
+		inString := st.quote != nil
+		kind, code := c.scan(line, &st, true)
+		if kind != codeLine {
+			continue
+		}
+		h.code++
+		branches += countBranches(string(code), c.branches)
+
+		if norm := strings.Join(strings.Fields(string(code)), " "); significant(norm) {
+			hash := fnv.New64a()
+			hash.Write([]byte(norm))
+			h.hashes = append(h.hashes, hash.Sum64())
+			h.lines = append(h.lines, n)
+		}
+
+		if c.function == nil {
+			continue
+		}
+
+		indent := len(line) - len(bytes.TrimLeft(line, " \t"))
+		if c.indentBlocks && !inString && !bytes.ContainsAny(line[indent:indent+1], ")]}") {
+			for len(stack) > 0 && indent <= stack[len(stack)-1].depth {
+				done(stack[len(stack)-1], lastCode)
+				stack = stack[:len(stack)-1]
+			}
+		}
+		lastCode = n
+
+		if name := functionName(c, code); name != "" {
+			// a declaration without a body, as a prototype or a Kotlin
+			// expression function, never opened
+			if len(stack) > 0 && !stack[len(stack)-1].opened {
+				stack = stack[:len(stack)-1]
+			}
+			f := open{function: function{name: name, line: n}, depth: depth}
+			if c.indentBlocks {
+				f.depth, f.opened = indent, true
+			}
+			stack = append(stack, f)
+		}
+
+		if c.indentBlocks {
+			continue
+		}
+		for _, b := range code {
+			switch b {
+			case '{':
+				if len(stack) > 0 && !stack[len(stack)-1].opened {
+					stack[len(stack)-1].opened = true
+				}
+				depth++
+			case '}':
+				depth--
+				if len(stack) > 0 && stack[len(stack)-1].opened && depth <= stack[len(stack)-1].depth {
+					done(stack[len(stack)-1], n)
+					stack = stack[:len(stack)-1]
+				}
+			case ';':
+				if len(stack) > 0 && !stack[len(stack)-1].opened {
+					stack = stack[:len(stack)-1]
+				}
+			}
+		}
+	}
+
+	// Python functions end with the file
+	for i := len(stack) - 1; i >= 0; i-- {
+		if stack[i].opened && c.indentBlocks {
+			done(stack[i], lastCode)
+		}
+	}
+
+	if len(c.branches) > 0 {
+		h.complexity = 1 + branches
+	}
+	return h
+}
+
+// functionName returns the name of the function starting in code, if any.
+func functionName(c Counter, code []byte) string {
+	m := c.function.FindSubmatch(code)
+	if m == nil {
+		return ""
+	}
+	if first := bytes.Fields(code); notNames[string(first[0])] {
+		return ""
+	}
+
+	// alternatives can each have a name group
+	for i, group := range c.function.SubexpNames() {
+		if group == "name" && len(m[i]) > 0 {
+			if name := string(m[i]); !notNames[name] {
+				return name
+			}
+			return ""
+		}
+	}
+	return ""
+}
+
+// countBranches counts the branches in code, keywords as words and
+// operators anywhere, longer ones first so ?? is not ? twice.
+func countBranches(code string, branches []string) int {
+	if len(branches) == 0 {
+		return 0
+	}
+
+	sorted := append([]string(nil), branches...)
+	sort.SliceStable(sorted, func(i, j int) bool { return len(sorted[i]) > len(sorted[j]) })
+
+	n := 0
+	for _, b := range sorted {
+		if !isWord(b) {
+			n += strings.Count(code, b)
+			code = strings.ReplaceAll(code, b, " ")
+			continue
+		}
+		for i := 0; ; {
+			j := strings.Index(code[i:], b)
+			if j < 0 {
+				break
+			}
+			start, end := i+j, i+j+len(b)
+			if (start == 0 || !isWordByte(code[start-1])) && (end == len(code) || !isWordByte(code[end])) {
+				n++
+			}
+			i = end
+		}
+	}
+	return n
+}
+
+func isWord(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if !isWordByte(s[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+func isWordByte(b byte) bool {
+	return b == '_' || '0' <= b && b <= '9' || 'a' <= b && b <= 'z' || 'A' <= b && b <= 'Z'
+}
+
+// significant lines have a letter or a digit, a lone } is in every file.
+func significant(line string) bool {
+	for i := 0; i < len(line); i++ {
+		if isWordByte(line[i]) {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *Result) AddHealth(h fileHealth) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.health = append(r.health, h)
+}
+
+// healthReport is the optional section after the counts, with the top
+// rows of each table.
+type healthReport struct {
+	top        int
+	files      []fileHealth
+	functions  []located
+	duplicates []duplicate
+	// significant code lines in a duplicate, and all code lines
+	dupLines, code int
+}
+
+type located struct {
+	path string
+	function
+}
+
+// duplicate is a block of lines found in several places.
+type duplicate struct {
+	lines  int
+	places []place
+}
+
+type place struct {
+	path       string
+	start, end int
+}
+
+func (p place) String() string {
+	return fmt.Sprintf("%s:%d-%d", p.path, p.start, p.end)
+}
+
+func (d duplicate) placeNames() []string {
+	places := make([]string, len(d.places))
+	for i, p := range d.places {
+		places[i] = p.String()
+	}
+	return places
+}
+
+func (r *Result) healthReport(window, top int) *healthReport {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	files := r.health
+	sort.Slice(files, func(i, j int) bool { return files[i].path < files[j].path })
+
+	rep := &healthReport{top: top}
+	for _, f := range files {
+		rep.code += f.code
+		if f.complexity > 0 {
+			rep.files = append(rep.files, f)
+		}
+		for _, fn := range f.funcs {
+			rep.functions = append(rep.functions, located{f.path, fn})
+		}
+	}
+	sort.SliceStable(rep.files, func(i, j int) bool { return rep.files[i].complexity > rep.files[j].complexity })
+	sort.SliceStable(rep.functions, func(i, j int) bool { return rep.functions[i].lines > rep.functions[j].lines })
+
+	rep.duplicates, rep.dupLines = duplicates(files, window)
+	return rep
+}
+
+// duplicates finds the blocks of at least window significant lines found
+// more than once, by rolling hashes of the windows of every file.
+func duplicates(files []fileHealth, window int) ([]duplicate, int) {
+	type loc struct{ file, i int }
+
+	const base = 1000003
+	pow := uint64(1)
+	for i := 0; i < window; i++ {
+		pow *= base
+	}
+
+	rolls := make([][]uint64, len(files))
+	seen := map[uint64][]loc{}
+	for fi, f := range files {
+		if len(f.hashes) < window {
+			continue
+		}
+		var roll uint64
+		for i, h := range f.hashes {
+			roll = roll*base + h
+			if i >= window {
+				roll -= f.hashes[i-window] * pow
+			}
+			if i >= window-1 {
+				rolls[fi] = append(rolls[fi], roll)
+				seen[roll] = append(seen[roll], loc{fi, i - window + 1})
+			}
+		}
+	}
+
+	same := func(a, b loc, n int) bool {
+		fa, fb := files[a.file], files[b.file]
+		if a.i+n+window > len(fa.hashes) || b.i+n+window > len(fb.hashes) {
+			return false
+		}
+		// blocks of one file can't overlap
+		if a.file == b.file && a.i+n+window > b.i {
+			return false
+		}
+		if rolls[a.file][a.i+n] != rolls[b.file][b.i+n] {
+			return false
+		}
+		for k := 0; k < window; k++ {
+			if fa.hashes[a.i+n+k] != fb.hashes[b.i+n+k] {
+				return false
+			}
+		}
+		return true
+	}
+
+	// each pair of places extended from where it starts, then the places
+	// of the same block merged
+	type key struct {
+		hash uint64
+		n    int
+	}
+	blocks := map[key]map[place]bool{}
+	var order []key
+	dup := make([][]bool, len(files))
+	for fi := range files {
+		dup[fi] = make([]bool, len(files[fi].hashes))
+	}
+
+	for fi := range files {
+		for i, roll := range rolls[fi] {
+			a := loc{fi, i}
+			for _, b := range seen[roll] {
+				if b.file < a.file || b.file == a.file && b.i <= a.i {
+					continue
+				}
+				if !same(a, b, 0) || a.i > 0 && b.i > 0 && same(loc{a.file, a.i - 1}, loc{b.file, b.i - 1}, 0) {
+					continue
+				}
+
+				n := 1
+				for same(a, b, n) {
+					n++
+				}
+				k := key{roll, n}
+				if blocks[k] == nil {
+					blocks[k] = map[place]bool{}
+					order = append(order, k)
+				}
+				for _, l := range []loc{a, b} {
+					f := files[l.file]
+					last := l.i + n + window - 2
+					blocks[k][place{f.path, f.lines[l.i], f.lines[last]}] = true
+					for j := l.i; j <= last; j++ {
+						dup[l.file][j] = true
+					}
+				}
+			}
+		}
+	}
+
+	var out []duplicate
+	for _, k := range order {
+		d := duplicate{lines: k.n + window - 1}
+		for p := range blocks[k] {
+			d.places = append(d.places, p)
+		}
+		sort.Slice(d.places, func(i, j int) bool {
+			a, b := d.places[i], d.places[j]
+			if a.path != b.path {
+				return a.path < b.path
+			}
+			return a.start < b.start
+		})
+		out = append(out, d)
+	}
+	sort.SliceStable(out, func(i, j int) bool {
+		if out[i].lines != out[j].lines {
+			return out[i].lines > out[j].lines
+		}
+		return len(out[i].places) > len(out[j].places)
+	})
+
+	total := 0
+	for _, lines := range dup {
+		for _, d := range lines {
+			if d {
+				total++
+			}
+		}
+	}
+	return out, total
+}
+
+func (rep *healthReport) percent() float64 {
+	if rep.code == 0 {
+		return 0
+	}
+	return float64(rep.dupLines) * 100 / float64(rep.code)
+}
+
+func (rep *healthReport) cut(n int) int {
+	if rep.top > 0 && n > rep.top {
+		return rep.top
+	}
+	return n
+}
+
+func (rep *healthReport) sections() []section {
+
+	complexity := section{
+		title:  "Complexity",
+		header: []string{"File", "Language", "Code", "Complexity"},
+		names:  2,
+	}
+	for _, f := range rep.files[:rep.cut(len(rep.files))] {
+		complexity.rows = append(complexity.rows, []string{f.path, f.lang, strconv.Itoa(f.code), strconv.Itoa(f.complexity)})
+	}
+
+	functions := section{
+		title:  "Longest functions",
+		header: []string{"Function", "File", "Lines"},
+		names:  2,
+	}
+	for _, f := range rep.functions[:rep.cut(len(rep.functions))] {
+		functions.rows = append(functions.rows, []string{f.name, f.path + ":" + strconv.Itoa(f.line), strconv.Itoa(f.lines)})
+	}
+
+	dups := section{
+		title:  fmt.Sprintf("Duplicated code: %d of %d code lines (%.1f%%)", rep.dupLines, rep.code, rep.percent()),
+		header: []string{"Places", "Lines"},
+		names:  1,
+	}
+	for _, d := range rep.duplicates[:rep.cut(len(rep.duplicates))] {
+		dups.rows = append(dups.rows, []string{strings.Join(d.placeNames(), " "), strconv.Itoa(d.lines)})
+	}
+
+	return []section{complexity, functions, dups}
+}
+
+// section is a table of names and then numbers.
+type section struct {
+	title  string
+	header []string
+	names  int
+	rows   [][]string
+}
+
+func (s section) printTable(w io.Writer) {
+	widths := make([]int, len(s.header))
+	for i, h := range s.header {
+		widths[i] = len(h)
+		if i >= s.names {
+			widths[i] = max(10, widths[i])
+		}
+	}
+	for _, row := range s.rows {
+		for i, cell := range row {
+			widths[i] = max(widths[i], len(cell))
+		}
+	}
+
+	borderLen := 1
+	for _, width := range widths {
+		borderLen += width + 1
+	}
+	border := strings.Repeat("━", max(borderLen, len(s.title)+2)) + "\n"
+
+	line := func(cells []string) {
+		for i, cell := range cells {
+			if i < s.names {
+				fmt.Fprintf(w, " %-*s", widths[i], cell)
+			} else {
+				fmt.Fprintf(w, " %*s", widths[i], cell)
+			}
+		}
+		fmt.Fprintln(w, " ")
+	}
+
+	fmt.Fprintf(w, " %s\n", s.title)
+	fmt.Fprint(w, border)
+	line(s.header)
+	fmt.Fprint(w, border)
+	for _, row := range s.rows {
+		line(row)
+	}
+	fmt.Fprint(w, border)
+}
+
+func (s section) printMarkdown(w io.Writer) {
+	fmt.Fprintf(w, "### %s\n\n", s.title)
+	fmt.Fprintf(w, "| %s |\n", strings.Join(s.header, " | "))
+	for i := range s.header {
+		if i < s.names {
+			fmt.Fprint(w, "| --- ")
+		} else {
+			fmt.Fprint(w, "| ---: ")
+		}
+	}
+	fmt.Fprintln(w, "|")
+	for _, row := range s.rows {
+		cells := make([]string, len(row))
+		for i, cell := range row {
+			cells[i] = strings.ReplaceAll(cell, "|", `\|`)
+		}
+		fmt.Fprintf(w, "| %s |\n", strings.Join(cells, " | "))
+	}
+}
+
+type jsonHealth struct {
+	Complexity []jsonComplexity `json:"complexity"`
+	Functions  []jsonFunction   `json:"functions"`
+	Duplicates jsonDuplicates   `json:"duplicates"`
+}
+
+type jsonComplexity struct {
+	Path       string `json:"path"`
+	Language   string `json:"language"`
+	Code       int    `json:"code"`
+	Complexity int    `json:"complexity"`
+}
+
+type jsonFunction struct {
+	Name  string `json:"name"`
+	Path  string `json:"path"`
+	Line  int    `json:"line"`
+	Lines int    `json:"lines"`
+}
+
+type jsonDuplicates struct {
+	Lines   int             `json:"lines"`
+	Percent float64         `json:"percent"`
+	Blocks  []jsonDuplicate `json:"blocks"`
+}
+
+type jsonDuplicate struct {
+	Lines  int      `json:"lines"`
+	Places []string `json:"places"`
+}
+
+func (rep *healthReport) json() jsonHealth {
+	j := jsonHealth{
+		Complexity: []jsonComplexity{},
+		Functions:  []jsonFunction{},
+		Duplicates: jsonDuplicates{
+			Lines:   rep.dupLines,
+			Percent: float64(int(rep.percent()*10+0.5)) / 10,
+			Blocks:  []jsonDuplicate{},
+		},
+	}
+	for _, f := range rep.files[:rep.cut(len(rep.files))] {
+		j.Complexity = append(j.Complexity, jsonComplexity{f.path, f.lang, f.code, f.complexity})
+	}
+	for _, f := range rep.functions[:rep.cut(len(rep.functions))] {
+		j.Functions = append(j.Functions, jsonFunction{f.name, f.path, f.line, f.lines})
+	}
+	for _, d := range rep.duplicates[:rep.cut(len(rep.duplicates))] {
+		j.Duplicates.Blocks = append(j.Duplicates.Blocks, jsonDuplicate{d.lines, d.placeNames()})
+	}
+	return j
+}
+
+func (j jsonHealth) printYAML(w io.Writer) {
+	fmt.Fprintln(w, "health:")
+
+	list := func(key string, n int, item func(i int) [][2]string) {
+		if n == 0 {
+			fmt.Fprintf(w, "  %s: []\n", key)
+			return
+		}
+		fmt.Fprintf(w, "  %s:\n", key)
+		for i := 0; i < n; i++ {
+			for k, kv := range item(i) {
+				prefix := "      "
+				if k == 0 {
+					prefix = "    - "
+				}
+				fmt.Fprintf(w, "%s%s: %s\n", prefix, kv[0], kv[1])
+			}
+		}
+	}
+
+	list("complexity", len(j.Complexity), func(i int) [][2]string {
+		c := j.Complexity[i]
+		return [][2]string{
+			{"path", strconv.Quote(c.Path)},
+			{"language", strconv.Quote(c.Language)},
+			{"code", strconv.Itoa(c.Code)},
+			{"complexity", strconv.Itoa(c.Complexity)},
+		}
+	})
+	list("functions", len(j.Functions), func(i int) [][2]string {
+		f := j.Functions[i]
+		return [][2]string{
+			{"name", strconv.Quote(f.Name)},
+			{"path", strconv.Quote(f.Path)},
+			{"line", strconv.Itoa(f.Line)},
+			{"lines", strconv.Itoa(f.Lines)},
+		}
+	})
+
+	fmt.Fprintln(w, "  duplicates:")
+	fmt.Fprintf(w, "    lines: %d\n", j.Duplicates.Lines)
+	fmt.Fprintf(w, "    percent: %s\n", strconv.FormatFloat(j.Duplicates.Percent, 'f', -1, 64))
+	if len(j.Duplicates.Blocks) == 0 {
+		fmt.Fprintln(w, "    blocks: []")
+		return
+	}
+	fmt.Fprintln(w, "    blocks:")
+	for _, d := range j.Duplicates.Blocks {
+		fmt.Fprintf(w, "      - lines: %d\n", d.Lines)
+		fmt.Fprintln(w, "        places:")
+		for _, p := range d.places() {
+			fmt.Fprintf(w, "          - %s\n", p)
+		}
+	}
+}
+
+func (d jsonDuplicate) places() []string {
+	quoted := make([]string, len(d.Places))
+	for i, p := range d.Places {
+		quoted[i] = strconv.Quote(p)
+	}
+	return quoted
+}
@@ -0,0 +1,449 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os/exec"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var steps = map[string]func(time.Time) time.Time{
+	"day":     func(t time.Time) time.Time { return t.AddDate(0, 0, 1) },
+	"week":    func(t time.Time) time.Time { return t.AddDate(0, 0, 7) },
+	"month":   func(t time.Time) time.Time { return t.AddDate(0, 1, 0) },
+	"quarter": func(t time.Time) time.Time { return t.AddDate(0, 3, 0) },
+	"year":    func(t time.Time) time.Time { return t.AddDate(1, 0, 0) },
+}
+
+var metrics = map[string]func(Item) int{
+	"files":    func(it Item) int { return it.files },
+	"lines":    func(it Item) int { return it.lines },
+	"code":     func(it Item) int { return it.code },
+	"comments": func(it Item) int { return it.comment },
+	"blanks":   func(it Item) int { return it.blank },
+}
+
+type commit struct {
+	hash string
+	time time.Time
+}
+
+// sample is the count of one commit of the history.
+type sample struct {
+	date   time.Time
+	commit commit
+	langs  map[string]Item
+	total  Item
+}
+
+// history counts the files of dir at the last commit before every step
+// since since, and at HEAD. The files are read from the git objects, the
+// work tree is not touched.
+func history(dir string, since time.Time, step func(time.Time) time.Time) ([]sample, error) {
+	commits, err := firstParents(dir)
+	if err != nil {
+		return nil, err
+	}
+	if len(commits) == 0 {
+		return nil, errors.New("no commits")
+	}
+	if since.IsZero() {
+		since = commits[0].time
+	}
+
+	var dates []time.Time
+	now := time.Now()
+	for t := since; t.Before(now); t = step(t) {
+		dates = append(dates, t)
+	}
+	dates = append(dates, now)
+
+	blobs, err := newBlobReader(dir)
+	if err != nil {
+		return nil, err
+	}
+	defer blobs.Close()
+
+	var samples []sample
+	for _, date := range dates {
+		// the last commit at date
+		i := sort.Search(len(commits), func(i int) bool { return commits[i].time.After(date) })
+		if i == 0 {
+			continue
+		}
+
+		s, err := countCommit(dir, commits[i-1], blobs)
+		if err != nil {
+			return nil, err
+		}
+		s.date = date
+		samples = append(samples, s)
+	}
+
+	return samples, nil
+}
+
+// firstParents returns the commits of the first parent chain of HEAD, the
+// oldest first.
+func firstParents(dir string) ([]commit, error) {
+	out, err := git(dir, "log", "--first-parent", "--format=%H %ct", "HEAD")
+	if err != nil {
+		return nil, err
+	}
+
+	var commits []commit
+	for _, line := range strings.Split(strings.TrimSpace(string(out)), "\n") {
+		hash, ts, ok := strings.Cut(line, " ")
+		if !ok {
+			continue
+		}
+		sec, err := strconv.ParseInt(ts, 10, 64)
+		if err != nil {
+			return nil, err
+		}
+		commits = append(commits, commit{hash, time.Unix(sec, 0)})
+	}
+
+	sort.SliceStable(commits, func(i, j int) bool { return commits[i].time.Before(commits[j].time) })
+	return commits, nil
+}
+
+func countCommit(dir string, c commit, blobs *blobReader) (sample, error) {
+	s := sample{commit: c, langs: make(map[string]Item)}
+
+	// paths are relative to dir, only the files below it are listed
+	out, err := git(dir, "ls-tree", "-r", "-l", "-z", c.hash)
+	if err != nil {
+		return s, err
+	}
+
+	for _, entry := range strings.Split(string(out), "\x00") {
+		// <mode> SP <type> SP <object> SP+ <size> TAB <path>
+		meta, path, ok := strings.Cut(entry, "\t")
+		if !ok {
+			continue
+		}
+		fields := strings.Fields(meta)
+		if len(fields) != 4 || fields[1] != "blob" {
+			continue
+		}
+		size, _ := strconv.ParseInt(fields[3], 10, 64)
+		if !keepPath(path, size) {
+			continue
+		}
+
+		item, ok, err := blobs.count(fields[2], path)
+		if err != nil {
+			return s, err
+		}
+		if ok {
+			s.langs[item.lang] = mergeItem(s.langs[item.lang], item)
+			s.total = mergeItem(s.total, item)
+		}
+	}
+
+	return s, nil
+}
+
+// keepPath applies the flags of the directory walk to a path of a tree,
+// there are no ignore files to read, what is committed counts.
+func keepPath(path string, size int64) bool {
+	if maxSize > 0 && size > int64(maxSize) {
+		return false
+	}
+	if len(includes) > 0 && !includes.match(path) {
+		return false
+	}
+
+	parts := strings.Split(path, "/")
+	for i, part := range parts {
+		if excludes.match(strings.Join(parts[:i+1], "/")) {
+			return false
+		}
+		if i < len(parts)-1 && !*hidden && strings.HasPrefix(part, ".") {
+			return false
+		}
+	}
+	return true
+}
+
+// blobReader reads blobs through one git cat-file process and remembers
+// their counts, most files don't change between two samples.
+type blobReader struct {
+	cmd    *exec.Cmd
+	in     io.WriteCloser
+	out    *bufio.Reader
+	counts map[string]blobCount
+}
+
+type blobCount struct {
+	item Item
+	ok   bool
+}
+
+func newBlobReader(dir string) (*blobReader, error) {
+	cmd := exec.Command("git", "cat-file", "--batch")
+	cmd.Dir = dir
+
+	in, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	out, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+
+	return &blobReader{cmd: cmd, in: in, out: bufio.NewReader(out), counts: make(map[string]blobCount)}, nil
+}
+
+// count counts the blob hash named path, the name is part of the key as it
+// decides the language.
+func (b *blobReader) count(hash, path string) (Item, bool, error) {
+	key := hash + "\x00" + baseName(path)
+	if bc, ok := b.counts[key]; ok {
+		return bc.item, bc.ok, nil
+	}
+
+	content, err := b.read(hash)
+	if err != nil {
+		return Item{}, false, err
+	}
+
+	_, item, ok := count(path, content)
+	b.counts[key] = blobCount{item, ok}
+	return item, ok, nil
+}
+
+func (b *blobReader) read(hash string) ([]byte, error) {
+	if _, err := fmt.Fprintln(b.in, hash); err != nil {
+		return nil, err
+	}
+
+	// <object> SP <type> SP <size> LF <contents> LF
+	header, err := b.out.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	fields := strings.Fields(header)
+	if len(fields) != 3 {
+		return nil, fmt.Errorf("git cat-file: %s", strings.TrimSpace(header))
+	}
+	size, err := strconv.Atoi(fields[2])
+	if err != nil {
+		return nil, err
+	}
+
+	content := make([]byte, size+1)
+	if _, err := io.ReadFull(b.out, content); err != nil {
+		return nil, err
+	}
+	return content[:size], nil
+}
+
+func (b *blobReader) Close() error {
+	b.in.Close()
+	return b.cmd.Wait()
+}
+
+func git(dir string, args ...string) ([]byte, error) {
+	cmd := exec.Command("git", args...)
+	cmd.Dir = dir
+
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("git %s: %v: %s", args[0], err, strings.TrimSpace(stderr.String()))
+	}
+	return out, nil
+}
+
+func baseName(path string) string {
+	return path[strings.LastIndexByte(path, '/')+1:]
+}
+
+// historyLangs are the languages of the samples, the largest at the last
+// sample first.
+func historyLangs(samples []sample, metric func(Item) int) []string {
+	seen := map[string]bool{}
+	var langs []string
+	for _, s := range samples {
+		for lang := range s.langs {
+			if !seen[lang] {
+				seen[lang] = true
+				langs = append(langs, lang)
+			}
+		}
+	}
+
+	last := samples[len(samples)-1].langs
+	sort.Slice(langs, func(i, j int) bool {
+		a, b := metric(last[langs[i]]), metric(last[langs[j]])
+		if a != b {
+			return a > b
+		}
+		return langs[i] < langs[j]
+	})
+	return langs
+}
+
+func printHistory(w io.Writer, samples []sample, format, metricName string) error {
+	if len(samples) == 0 {
+		return errors.New("no commits in the time range")
+	}
+	metric := metrics[metricName]
+	langs := historyLangs(samples, metric)
+
+	switch format {
+	case "table", "markdown":
+		header := append(append([]string{"Date", "Commit"}, langs...), "Total")
+		rows := [][]string{}
+		for _, s := range samples {
+			row := []string{s.date.Format("2006-01-02"), s.commit.hash[:10]}
+			for _, lang := range langs {
+				row = append(row, strconv.Itoa(metric(s.langs[lang])))
+			}
+			rows = append(rows, append(row, strconv.Itoa(metric(s.total))))
+		}
+		if format == "markdown" {
+			printMarkdownGrid(w, header, rows)
+		} else {
+			printGrid(w, header, rows)
+		}
+		return nil
+
+	case "csv":
+		// long format, one row per sample and language
+		cw := csv.NewWriter(w)
+		cw.Write([]string{"date", "commit", "language", "files", "lines", "code", "comments", "blanks"})
+		for _, s := range samples {
+			for _, lang := range append(langs, "Total") {
+				it, ok := s.langs[lang]
+				if lang == "Total" {
+					it, ok = s.total, true
+				}
+				if !ok {
+					continue
+				}
+				cw.Write([]string{s.date.Format("2006-01-02"), s.commit.hash, lang, strconv.Itoa(it.files),
+					strconv.Itoa(it.lines), strconv.Itoa(it.code), strconv.Itoa(it.comment), strconv.Itoa(it.blank)})
+			}
+		}
+		cw.Flush()
+		return cw.Error()
+
+	case "json", "yaml":
+		type jsonSample struct {
+			Date      string              `json:"date"`
+			Commit    string              `json:"commit"`
+			Languages map[string]jsonItem `json:"languages"`
+			Total     jsonItem            `json:"total"`
+		}
+		var out []jsonSample
+		for _, s := range samples {
+			js := jsonSample{
+				Date:      s.date.Format("2006-01-02"),
+				Commit:    s.commit.hash,
+				Languages: make(map[string]jsonItem),
+				Total:     report{}.jsonItem(s.total, true),
+			}
+			for lang, it := range s.langs {
+				j := report{}.jsonItem(it, false)
+				j.Language = ""
+				js.Languages[lang] = j
+			}
+			out = append(out, js)
+		}
+
+		if format == "json" {
+			enc := json.NewEncoder(w)
+			enc.SetIndent("", "  ")
+			return enc.Encode(out)
+		}
+
+		for _, js := range out {
+			fmt.Fprintf(w, "- date: %s\n  commit: %s\n  languages:\n", js.Date, js.Commit)
+			for _, lang := range langs {
+				j, ok := js.Languages[lang]
+				if !ok {
+					continue
+				}
+				fmt.Fprintf(w, "    %s:\n", strconv.Quote(lang))
+				for _, kv := range yamlFields(j) {
+					fmt.Fprintf(w, "      %s: %s\n", kv[0], kv[1])
+				}
+			}
+			fmt.Fprintln(w, "  total:")
+			for _, kv := range yamlFields(js.Total) {
+				fmt.Fprintf(w, "    %s: %s\n", kv[0], kv[1])
+			}
+		}
+		return nil
+
+	default:
+		return fmt.Errorf("unknown format %q", format)
+	}
+}
+
+// printGrid prints a box table, the first columns left aligned and the
+// counts right aligned.
+func printGrid(w io.Writer, header []string, rows [][]string) {
+	widths := make([]int, len(header))
+	for _, row := range append([][]string{header}, rows...) {
+		for i, cell := range row {
+			widths[i] = max(widths[i], len(cell))
+		}
+	}
+
+	borderLen := 1
+	for _, width := range widths {
+		borderLen += width + 1
+	}
+	border := strings.Repeat("━", borderLen) + "\n"
+
+	line := func(row []string) {
+		for i, cell := range row {
+			if i < 2 {
+				fmt.Fprintf(w, " %-*s", widths[i], cell)
+			} else {
+				fmt.Fprintf(w, " %*s", widths[i], cell)
+			}
+		}
+		fmt.Fprintln(w, " ")
+	}
+
+	fmt.Fprint(w, border)
+	line(header)
+	fmt.Fprint(w, border)
+	for _, row := range rows {
+		line(row)
+	}
+	fmt.Fprint(w, border)
+}
+
+func printMarkdownGrid(w io.Writer, header []string, rows [][]string) {
+	fmt.Fprintf(w, "| %s |\n", strings.Join(header, " | "))
+	for i := range header {
+		if i < 2 {
+			fmt.Fprint(w, "| --- ")
+		} else {
+			fmt.Fprint(w, "| ---: ")
+		}
+	}
+	fmt.Fprintln(w, "|")
+	for _, row := range rows {
+		fmt.Fprintf(w, "| %s |\n", strings.Join(row, " | "))
+	}
+}
@@ -0,0 +1,266 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// ignoreFiles are read in every directory, .ignore is for what should not
+// be counted but is still tracked by git.
+var ignoreFiles = []string{".gitignore", ".ignore"}
+
+// ignorePattern is one line of a gitignore file.
+type ignorePattern struct {
+	glob    string
+	negate  bool
+	dirOnly bool
+	// patterns with a slash are relative to base, the directory of their
+	// file, others match names at any depth below it
+	anchored bool
+	base     string
+}
+
+func parseIgnore(data []byte, base string) []ignorePattern {
+	var patterns []ignorePattern
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), " \t\r")
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		p := ignorePattern{base: base}
+		if strings.HasPrefix(line, "!") {
+			p.negate = true
+			line = line[1:]
+		}
+		line = strings.TrimPrefix(line, `\`)
+		if strings.HasSuffix(line, "/") {
+			p.dirOnly = true
+			line = strings.TrimRight(line, "/")
+		}
+		if strings.Contains(line, "/") {
+			p.anchored = true
+			line = strings.TrimPrefix(line, "/")
+		}
+		if line == "" {
+			continue
+		}
+
+		p.glob = line
+		patterns = append(patterns, p)
+	}
+	return patterns
+}
+
+// match reports whether the pattern matches rel, a slash separated path
+// relative to the top of the walk.
+func (p ignorePattern) match(rel string, isDir bool) bool {
+	if p.dirOnly && !isDir {
+		return false
+	}
+
+	if p.base != "" {
+		if !strings.HasPrefix(rel, p.base+"/") {
+			return false
+		}
+		rel = rel[len(p.base)+1:]
+	}
+
+	if !p.anchored {
+		return matchGlob(p.glob, path.Base(rel))
+	}
+	return matchGlob(p.glob, rel)
+}
+
+// matchGlob matches path.Match patterns segment by segment, with ** for
+// any number of segments.
+func matchGlob(pattern, name string) bool {
+	return matchSegments(strings.Split(pattern, "/"), strings.Split(name, "/"))
+}
+
+func matchSegments(pattern, name []string) bool {
+	for len(pattern) > 0 {
+		if pattern[0] == "**" {
+			for i := 0; i <= len(name); i++ {
+				if matchSegments(pattern[1:], name[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+
+		if len(name) == 0 {
+			return false
+		}
+		if ok, _ := path.Match(pattern[0], name[0]); !ok {
+			return false
+		}
+		pattern, name = pattern[1:], name[1:]
+	}
+	return len(name) == 0
+}
+
+// ignorer knows the ignore files of the directories walked so far. Paths
+// are relative to top, the root of the git repository around the walk if
+// there is one, so the ignore files above the walked directory apply too.
+type ignorer struct {
+	top    string
+	global []ignorePattern
+	dirs   map[string][]ignorePattern
+}
+
+func newIgnorer(root string) *ignorer {
+	abs, err := filepath.Abs(root)
+	if err != nil {
+		abs = root
+	}
+
+	ig := &ignorer{top: abs, dirs: make(map[string][]ignorePattern)}
+	gitRoot, inRepo := findGitRoot(abs)
+	if inRepo {
+		ig.top = gitRoot
+	}
+
+	// lowest precedence first
+	if data, err := os.ReadFile(globalExcludesFile()); err == nil {
+		ig.global = append(ig.global, parseIgnore(data, "")...)
+	}
+	if inRepo {
+		if data, err := os.ReadFile(filepath.Join(gitRoot, ".git", "info", "exclude")); err == nil {
+			ig.global = append(ig.global, parseIgnore(data, "")...)
+		}
+	}
+
+	// the directories between the repository and the walk
+	dir := ig.top
+	for {
+		ig.load(dir)
+		rel, err := filepath.Rel(dir, abs)
+		if err != nil || rel == "." || strings.HasPrefix(rel, "..") {
+			break
+		}
+		dir = filepath.Join(dir, strings.Split(rel, string(filepath.Separator))[0])
+	}
+
+	return ig
+}
+
+// rel returns abs relative to top, slash separated.
+func (ig *ignorer) rel(abs string) string {
+	rel, err := filepath.Rel(ig.top, abs)
+	if err != nil {
+		return filepath.ToSlash(abs)
+	}
+	return filepath.ToSlash(rel)
+}
+
+// load reads the ignore files of dir, an absolute path.
+func (ig *ignorer) load(dir string) {
+	base := ig.rel(dir)
+	if base == "." {
+		base = ""
+	}
+
+	var patterns []ignorePattern
+	for _, name := range ignoreFiles {
+		if data, err := os.ReadFile(filepath.Join(dir, name)); err == nil {
+			patterns = append(patterns, parseIgnore(data, base)...)
+		}
+	}
+	if len(patterns) > 0 {
+		ig.dirs[base] = patterns
+	}
+}
+
+// ignored reports whether abs is ignored, the last matching pattern of the
+// deepest ignore file wins.
+func (ig *ignorer) ignored(abs string, isDir bool) bool {
+	rel := ig.rel(abs)
+	if rel == "." || strings.HasPrefix(rel, "../") {
+		return false
+	}
+
+	ignored := false
+	check := func(patterns []ignorePattern) {
+		for _, p := range patterns {
+			if p.match(rel, isDir) {
+				ignored = !p.negate
+			}
+		}
+	}
+
+	check(ig.global)
+	check(ig.dirs[""])
+	for i := 0; i < len(rel); i++ {
+		if rel[i] == '/' {
+			check(ig.dirs[rel[:i]])
+		}
+	}
+
+	return ignored
+}
+
+func findGitRoot(dir string) (string, bool) {
+	for {
+		if _, err := os.Stat(filepath.Join(dir, ".git")); err == nil {
+			return dir, true
+		}
+
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return "", false
+		}
+		dir = parent
+	}
+}
+
+// globalExcludesFile is core.excludesFile of the git config of the user,
+// by default $XDG_CONFIG_HOME/git/ignore.
+func globalExcludesFile() string {
+	home, _ := os.UserHomeDir()
+	configDir, err := os.UserConfigDir()
+	if err != nil {
+		configDir = filepath.Join(home, ".config")
+	}
+
+	file := filepath.Join(configDir, "git", "ignore")
+	for _, config := range []string{filepath.Join(configDir, "git", "config"), filepath.Join(home, ".gitconfig")} {
+		if f := gitConfigValue(config, "core", "excludesfile"); f != "" {
+			file = f
+		}
+	}
+
+	if strings.HasPrefix(file, "~/") {
+		file = filepath.Join(home, file[2:])
+	}
+	return file
+}
+
+// gitConfigValue reads key in section of a git config file, without
+// includes or subsections.
+func gitConfigValue(file, section, key string) string {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return ""
+	}
+
+	var cur, value string
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		switch {
+		case strings.HasPrefix(line, "["):
+			cur = strings.ToLower(strings.Trim(line, "[] \t"))
+		case cur == section:
+			k, v, ok := strings.Cut(line, "=")
+			if ok && strings.ToLower(strings.TrimSpace(k)) == key {
+				value = strings.Trim(strings.TrimSpace(v), `"`)
+			}
+		}
+	}
+	return value
+}
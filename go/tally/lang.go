@@ -0,0 +1,203 @@
+package main
+
+import (
+	"bytes"
+	"regexp"
+)
+
+// Counter knows the comment and string syntax of a language, which is all
+// it takes to tell code, comment and blank lines apart.
+type Counter struct {
+	idx  int
+	lang string
+	exts []string
+
+	// line comment markers
+	line []string
+	// block comments, nested ones need to be closed as often as opened
+	block  []Delim
+	nested bool
+	// strings that are comments when they start a line, Python docstrings
+	doc []Delim
+	// string literals, comment markers in them are code
+	quotes []Quote
+
+	// for the health report, see langDef
+	branches     []string
+	function     *regexp.Regexp
+	indentBlocks bool
+}
+
+type Delim struct {
+	start, end string
+}
+
+type Quote struct {
+	Delim
+	// raw strings have no backslash escapes
+	raw bool
+	// strings that are not multi-line end with the line anyway, so a stray
+	// quote can't swallow the rest of the file
+	multiLine bool
+}
+
+type lineKind int
+
+const (
+	blankLine lineKind = iota
+	codeLine
+	commentLine
+)
+
+// lineState is what a line leaves open for the next one.
+type lineState struct {
+	// depth of the block comment we are in
+	depth int
+	block Delim
+	// docstring or string we are in
+	doc   *Delim
+	quote *Quote
+}
+
+// classify tells what line is, a line with any code is code even if it has
+// a comment too.
+func (c Counter) classify(line []byte, st *lineState) lineKind {
+	kind, _ := c.scan(line, st, false)
+	return kind
+}
+
+// scan classifies line and, with keep, also returns its code: without
+// comments, strings reduced to their quotes.
+func (c Counter) scan(line []byte, st *lineState, keep bool) (lineKind, []byte) {
+	var (
+		hasCode, hasComment bool
+		code                []byte
+	)
+	if len(bytes.TrimSpace(line)) == 0 {
+		return blankLine, nil
+	}
+
+	i := 0
+	for i < len(line) {
+		rest := line[i:]
+		switch {
+		case st.depth > 0:
+			hasComment = true
+			if c.nested && bytes.HasPrefix(rest, []byte(st.block.start)) {
+				st.depth++
+				i += len(st.block.start)
+			} else if bytes.HasPrefix(rest, []byte(st.block.end)) {
+				st.depth--
+				i += len(st.block.end)
+			} else {
+				i++
+			}
+
+		case st.doc != nil:
+			hasComment = true
+			if bytes.HasPrefix(rest, []byte(st.doc.end)) {
+				i += len(st.doc.end)
+				st.doc = nil
+			} else {
+				i++
+			}
+
+		case st.quote != nil:
+			hasCode = true
+			if !st.quote.raw && rest[0] == '\\' {
+				i += 2
+			} else if bytes.HasPrefix(rest, []byte(st.quote.end)) {
+				if keep {
+					code = append(code, st.quote.end...)
+				}
+				i += len(st.quote.end)
+				st.quote = nil
+			} else {
+				i++
+			}
+
+		default:
+			n := c.open(rest, !hasCode, st)
+			switch {
+			case n < 0:
+				// a line comment takes the rest of the line
+				hasComment = true
+				i = len(line)
+			case n > 0:
+				if st.quote != nil {
+					hasCode = true
+					if keep {
+						code = append(code, rest[:n]...)
+					}
+				} else {
+					hasComment = true
+				}
+				i += n
+			case isSpace(rest[0]):
+				if keep {
+					code = append(code, ' ')
+				}
+				i++
+			default:
+				hasCode = true
+				if keep {
+					code = append(code, rest[0])
+				}
+				i++
+			}
+		}
+	}
+
+	if st.quote != nil && !st.quote.multiLine {
+		st.quote = nil
+	}
+
+	switch {
+	case hasCode:
+		return codeLine, code
+	case hasComment:
+		return commentLine, nil
+	default:
+		return blankLine, nil
+	}
+}
+
+// open checks whether a comment or string starts at s and updates st.
+// It returns the length of the opening delimiter, or -1 for a line comment.
+func (c Counter) open(s []byte, lineStart bool, st *lineState) int {
+	// blocks first, --[[ in Lua is not a line comment
+	for _, b := range c.block {
+		if bytes.HasPrefix(s, []byte(b.start)) {
+			st.depth, st.block = 1, b
+			return len(b.start)
+		}
+	}
+
+	for _, m := range c.line {
+		if bytes.HasPrefix(s, []byte(m)) {
+			return -1
+		}
+	}
+
+	if lineStart {
+		for i, d := range c.doc {
+			if bytes.HasPrefix(s, []byte(d.start)) {
+				st.doc = &c.doc[i]
+				return len(d.start)
+			}
+		}
+	}
+
+	for i, q := range c.quotes {
+		if bytes.HasPrefix(s, []byte(q.start)) {
+			st.quote = &c.quotes[i]
+			return len(q.start)
+		}
+	}
+
+	return 0
+}
+
+func isSpace(b byte) bool {
+	return b == ' ' || b == '\t' || b == '\r' || b == '\n' || b == '\f' || b == '\v'
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestMain(m *testing.M) {
+	if err := loadLanguages(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	os.Exit(m.Run())
+}
+
+type golden struct {
+	file string
+	want Item
+}
+
+// readGolden reads testdata/lang/counts.golden, a line per fixture.
+func readGolden(t *testing.T) []golden {
+	f, err := os.Open(filepath.Join("testdata", "lang", "counts.golden"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	var out []golden
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		fields := strings.Fields(line)
+		if len(fields) < 6 {
+			t.Fatalf("bad golden line %q", line)
+		}
+		var n [4]int
+		for i := range n {
+			if n[i], err = strconv.Atoi(fields[i+1]); err != nil {
+				t.Fatalf("bad golden line %q: %v", line, err)
+			}
+		}
+		out = append(out, golden{fields[0], Item{
+			lang:    strings.Join(fields[5:], " "),
+			files:   1,
+			lines:   n[0],
+			code:    n[1],
+			comment: n[2],
+			blank:   n[3],
+		}})
+	}
+	if err := scanner.Err(); err != nil {
+		t.Fatal(err)
+	}
+	return out
+}
+
+func TestCount(t *testing.T) {
+	tests := readGolden(t)
+
+	covered := map[string]bool{}
+	for _, tt := range tests {
+		covered[tt.want.lang] = true
+		t.Run(tt.file, func(t *testing.T) {
+			path := filepath.Join("testdata", "lang", tt.file)
+			content, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			_, got, ok := count(path, content)
+			if !ok {
+				t.Fatalf("count(%s) not ok", tt.file)
+			}
+			if got != tt.want {
+				t.Errorf("count(%s) = %+v, want %+v", tt.file, got, tt.want)
+			}
+		})
+	}
+
+	for _, c := range languages {
+		if !covered[c.lang] {
+			t.Errorf("no fixture for %s", c.lang)
+		}
+	}
+}
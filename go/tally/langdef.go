@@ -0,0 +1,264 @@
+package main
+
+import (
+	"bytes"
+	_ "embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+//go:embed languages.json
+var builtinLanguages []byte
+
+// langFile is the format of languages.json and of user overrides.
+type langFile struct {
+	Languages  map[string]langDef    `json:"languages"`
+	Heuristics map[string]*heuristic `json:"heuristics"`
+}
+
+type langDef struct {
+	Extensions []string `json:"extensions"`
+	// names of files without a useful extension, as Makefile
+	Filenames []string `json:"filenames"`
+	// interpreters of #! lines, without path and version
+	Shebangs []string `json:"shebangs"`
+
+	LineComment  []string    `json:"line_comment"`
+	BlockComment [][2]string `json:"block_comment"`
+	Nested       bool        `json:"nested"`
+	DocQuotes    [][2]string `json:"doc_quotes"`
+	// quotes have backslash escapes and end with the line, multi-line
+	// quotes don't end with it, raw quotes have no escapes and verbatim
+	// quotes have neither
+	Quotes          [][2]string `json:"quotes"`
+	MultiLineQuotes [][2]string `json:"multi_line_quotes"`
+	RawQuotes       [][2]string `json:"raw_quotes"`
+	VerbatimQuotes  [][2]string `json:"verbatim_quotes"`
+
+	// keywords and operators adding a path through the code, for the
+	// complexity estimate
+	Branches []string `json:"branches"`
+	// regular expression matching the first line of a function in code
+	// without comments and strings, with a name group
+	Function string `json:"function"`
+	// functions end with their indentation rather than their braces
+	IndentBlocks bool `json:"indent_blocks"`
+}
+
+// heuristic picks the language of an extension several languages use, the
+// first rule matching the content wins.
+type heuristic struct {
+	Default string `json:"default"`
+	Rules   []struct {
+		Language string `json:"language"`
+		Pattern  string `json:"pattern"`
+	} `json:"rules"`
+
+	patterns []*regexp.Regexp
+}
+
+func (h *heuristic) guess(content []byte) Counter {
+	for i, re := range h.patterns {
+		if re.Match(content) {
+			return name2Counter[h.Rules[i].Language]
+		}
+	}
+	return name2Counter[h.Default]
+}
+
+var (
+	languages       []Counter
+	name2Counter    = map[string]Counter{}
+	ext2Counter     = map[string]Counter{}
+	file2Counter    = map[string]Counter{}
+	shebang2Counter = map[string]Counter{}
+	heuristics      = map[string]*heuristic{}
+)
+
+// loadLanguages loads the builtin languages, then the files of the user
+// which replace languages of the same name and take over their extensions.
+func loadLanguages(overrides ...string) error {
+	var defs langFile
+	if err := json.Unmarshal(builtinLanguages, &defs); err != nil {
+		return fmt.Errorf("builtin languages: %w", err)
+	}
+
+	var userNames []string
+	for _, path := range overrides {
+		raw, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+
+		var user langFile
+		if err := json.Unmarshal(raw, &user); err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+		for name, def := range user.Languages {
+			defs.Languages[name] = def
+			userNames = append(userNames, name)
+		}
+		for ext, h := range user.Heuristics {
+			defs.Heuristics[ext] = h
+		}
+	}
+
+	names := make([]string, 0, len(defs.Languages))
+	for name := range defs.Languages {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		registedNum++
+		c, err := defs.Languages[name].counter(registedNum, name)
+		if err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+		languages = append(languages, c)
+		name2Counter[name] = c
+	}
+
+	// user languages again at the end, so they win
+	for _, name := range append(names, userNames...) {
+		c, def := name2Counter[name], defs.Languages[name]
+		for _, ext := range def.Extensions {
+			ext2Counter[strings.ToLower(ext)] = c
+		}
+		for _, file := range def.Filenames {
+			file2Counter[file] = c
+		}
+		for _, interp := range def.Shebangs {
+			shebang2Counter[interp] = c
+		}
+	}
+
+	for ext, h := range defs.Heuristics {
+		if _, ok := name2Counter[h.Default]; !ok {
+			return fmt.Errorf("heuristic of .%s: unknown language %q", ext, h.Default)
+		}
+		for _, rule := range h.Rules {
+			if _, ok := name2Counter[rule.Language]; !ok {
+				return fmt.Errorf("heuristic of .%s: unknown language %q", ext, rule.Language)
+			}
+			re, err := regexp.Compile(rule.Pattern)
+			if err != nil {
+				return fmt.Errorf("heuristic of .%s: %w", ext, err)
+			}
+			h.patterns = append(h.patterns, re)
+		}
+		heuristics[strings.ToLower(ext)] = h
+	}
+
+	return nil
+}
+
+func (d langDef) counter(idx int, name string) (Counter, error) {
+	c := Counter{
+		idx:          idx,
+		lang:         name,
+		exts:         d.Extensions,
+		line:         append([]string(nil), d.LineComment...),
+		nested:       d.Nested,
+		branches:     d.Branches,
+		indentBlocks: d.IndentBlocks,
+	}
+
+	if d.Function != "" {
+		re, err := regexp.Compile(d.Function)
+		if err != nil {
+			return c, err
+		}
+		if re.SubexpIndex("name") < 0 {
+			return c, errors.New("function pattern has no name group")
+		}
+		c.function = re
+	}
+
+	for _, b := range d.BlockComment {
+		c.block = append(c.block, Delim{b[0], b[1]})
+	}
+	for _, q := range d.DocQuotes {
+		c.doc = append(c.doc, Delim{q[0], q[1]})
+	}
+
+	for _, kind := range []struct {
+		quotes         [][2]string
+		raw, multiLine bool
+	}{
+		{d.Quotes, false, false},
+		{d.MultiLineQuotes, false, true},
+		{d.RawQuotes, true, false},
+		{d.VerbatimQuotes, true, true},
+	} {
+		for _, q := range kind.quotes {
+			c.quotes = append(c.quotes, Quote{Delim{q[0], q[1]}, kind.raw, kind.multiLine})
+		}
+	}
+
+	// longest delimiters first, """ is not "
+	sort.SliceStable(c.line, func(i, j int) bool { return len(c.line[i]) > len(c.line[j]) })
+	sort.SliceStable(c.block, func(i, j int) bool { return len(c.block[i].start) > len(c.block[j].start) })
+	sort.SliceStable(c.doc, func(i, j int) bool { return len(c.doc[i].start) > len(c.doc[j].start) })
+	sort.SliceStable(c.quotes, func(i, j int) bool { return len(c.quotes[i].start) > len(c.quotes[j].start) })
+
+	return c, nil
+}
+
+// guessLang tells the language of a file by its name, its extension or the
+// interpreter of its #! line.
+func guessLang(path string, content []byte) Counter {
+	name := filepath.Base(path)
+	if c, ok := file2Counter[name]; ok {
+		return c
+	}
+
+	ext := strings.ToLower(strings.TrimPrefix(filepath.Ext(name), "."))
+	if h, ok := heuristics[ext]; ok {
+		return h.guess(content)
+	}
+	if c, ok := ext2Counter[ext]; ok {
+		return c
+	}
+
+	// Dockerfile.dev
+	if base, _, ok := strings.Cut(name, "."); ok {
+		if c, ok := file2Counter[base]; ok {
+			return c
+		}
+	}
+
+	return shebangLang(content)
+}
+
+func shebangLang(content []byte) Counter {
+	if !bytes.HasPrefix(content, []byte("#!")) {
+		return Counter{}
+	}
+
+	line, _, _ := bytes.Cut(content[2:], []byte("\n"))
+	fields := strings.Fields(string(line))
+	if len(fields) == 0 {
+		return Counter{}
+	}
+
+	interp := filepath.Base(fields[0])
+	if interp == "env" {
+		// #!/usr/bin/env -S python3 -u
+		for _, f := range fields[1:] {
+			if !strings.HasPrefix(f, "-") && !strings.Contains(f, "=") {
+				interp = f
+				break
+			}
+		}
+	}
+
+	// python3.11 is python
+	return shebang2Counter[strings.TrimRight(interp, "0123456789.")]
+}
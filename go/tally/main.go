@@ -3,62 +3,41 @@ package main
 import (
 	"bufio"
 	"bytes"
+	"errors"
+	"flag"
 	"fmt"
+	"io"
+	"io/fs"
 	"os"
+	"path"
 	"path/filepath"
 	"runtime"
-	"sort"
+	"strconv"
 	"strings"
 	"sync"
+	"time"
 )
 
-type Counter struct {
-	idx              int
-	lang             string
-	comment          string
-	multiLineComment []string
-	exts             []string
-}
+var registedNum = 0
+
+var result *Result
+
+var fileChan = make(chan string, 100)
 
 var (
-	Go       = Counter{1, "Go", "//", vec("/*", "*/"), vec(".go")}
-	Rust     = Counter{2, "Rust", "//", nil, vec(".rs")}
-	Java     = Counter{3, "Java", "//", nil, vec(".java")}
-	Python   = Counter{4, "Python", "#", nil, vec(".py")}
-	C        = Counter{5, "C", "//", nil, vec(".c", ".h")}
-	Cpp      = Counter{6, "C++", "//", nil, vec(".cpp", ".hpp")}
-	Js       = Counter{7, "Javascript", "//", nil, vec(".js")}
-	Ts       = Counter{8, "Typescript", "//", nil, vec(".ts")}
-	HTML     = Counter{9, "HTML", "//", nil, vec(".html", ".htm")}
-	JSON     = Counter{10, "JSON", "//", nil, vec(".json")}
-	Protobuf = Counter{11, "Protobuf", "//", nil, vec(".proto")}
-	Markdown = Counter{12, "Markdown", "//", nil, vec(".md")}
-	Shell    = Counter{13, "Shell", "#", nil, vec(".sh")}
-	YAML     = Counter{14, "YAML", "#", nil, vec(".yaml", ".yml")}
+	noIgnore = flag.Bool("no-ignore", false, "don't read .gitignore, .ignore and the global git excludes")
+	hidden   = flag.Bool("hidden", false, "walk hidden directories too, .git never")
+	maxSize  = sizeFlag(1 << 20)
+	excludes globsFlag
+	includes globsFlag
 )
 
-var ext2Counter = map[string]Counter{}
-var registedNum = 0
-
 func init() {
-	for _, c := range []Counter{
-		Go, Rust, Java, Python, C, Cpp, Js, Ts, HTML, JSON, Protobuf, Markdown, Shell, YAML,
-	} {
-		registedNum++
-		for _, ext := range c.exts {
-			ext2Counter[ext] = c
-		}
-	}
-
-	result = &Result{
-		data: make([]Item, registedNum),
-	}
+	flag.Var(&maxSize, "max-size", "skip files larger than this, as 512K or 2M, 0 for no limit")
+	flag.Var(&excludes, "exclude", "skip files and directories matching this glob, can be repeated")
+	flag.Var(&includes, "include", "only count files matching this glob, can be repeated")
 }
 
-var result *Result
-
-var fileChan = make(chan string, 100)
-
 func process(dir string) {
 	var wg sync.WaitGroup
 	wg.Add(runtime.NumCPU() * 2)
@@ -72,15 +51,50 @@ func process(dir string) {
 		}()
 	}
 
-	filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+	var ig *ignorer
+	if !*noIgnore {
+		ig = newIgnorer(dir)
+	}
+
+	filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
 		if err != nil {
 			panic(err)
 		}
 
-		if info.IsDir() {
+		rel, _ := filepath.Rel(dir, path)
+		rel = filepath.ToSlash(rel)
+		if rel != "." && excludes.match(rel) {
+			return skip(d)
+		}
+
+		var abs string
+		if ig != nil {
+			abs, _ = filepath.Abs(path)
+			if ig.ignored(abs, d.IsDir()) {
+				return skip(d)
+			}
+		}
+
+		if d.IsDir() {
+			if rel != "." && (d.Name() == ".git" || !*hidden && strings.HasPrefix(d.Name(), ".")) {
+				return filepath.SkipDir
+			}
+			if ig != nil {
+				ig.load(abs)
+			}
+			return nil
+		}
+
+		if !d.Type().IsRegular() || len(includes) > 0 && !includes.match(rel) {
 			return nil
 		}
 
+		if maxSize > 0 {
+			if info, err := d.Info(); err != nil || info.Size() > int64(maxSize) {
+				return nil
+			}
+		}
+
 		fileChan <- path
 
 		return nil
@@ -91,18 +105,200 @@ func process(dir string) {
 	wg.Wait()
 }
 
+func skip(d fs.DirEntry) error {
+	if d.IsDir() {
+		return filepath.SkipDir
+	}
+	return nil
+}
+
+// globsFlag matches like gitignore patterns without negation: a glob
+// without a slash matches names at any depth, one with a slash the path
+// from the walked directory.
+type globsFlag []string
+
+func (g *globsFlag) String() string {
+	return strings.Join(*g, ",")
+}
+
+func (g *globsFlag) Set(s string) error {
+	*g = append(*g, s)
+	return nil
+}
+
+func (g globsFlag) match(rel string) bool {
+	for _, glob := range g {
+		glob = strings.TrimSuffix(glob, "/")
+		if strings.Contains(glob, "/") {
+			if matchGlob(strings.TrimPrefix(glob, "/"), rel) {
+				return true
+			}
+		} else if matchGlob(glob, path.Base(rel)) {
+			return true
+		}
+	}
+	return false
+}
+
+// sizeFlag is a number of bytes with an optional K, M or G suffix.
+type sizeFlag int64
+
+func (s *sizeFlag) String() string {
+	return strconv.FormatInt(int64(*s), 10)
+}
+
+func (s *sizeFlag) Set(v string) error {
+	if v == "" {
+		return errors.New("empty size")
+	}
+
+	mult := int64(1)
+	switch strings.ToUpper(v[len(v)-1:]) {
+	case "K":
+		mult = 1 << 10
+	case "M":
+		mult = 1 << 20
+	case "G":
+		mult = 1 << 30
+	}
+	if mult > 1 {
+		v = v[:len(v)-1]
+	}
+
+	n, err := strconv.ParseInt(v, 10, 64)
+	if err != nil {
+		return err
+	}
+	*s = sizeFlag(n * mult)
+	return nil
+}
+
+var (
+	format  = flag.String("format", "table", "output format: "+strings.Join(formats, ", "))
+	perFile = flag.Bool("files", false, "a row per file instead of per language")
+	sortBy  = flag.String("sort", "lines", "column to sort by: "+strings.Join(sortColumns, ", "))
+)
+
+var (
+	gitMode = flag.Bool("git", false, "count the git history of <path>, at every -step since -since")
+	since   = flag.String("since", "", "first date of -git, as 2024-01-01, default the first commit")
+	step    = flag.String("step", "month", "time between the commits counted with -git: day, week, month, quarter or year")
+	metric  = flag.String("metric", "code", "count shown per language in -git tables: files, lines, code, comments or blanks")
+)
+
+var (
+	health   = flag.Bool("health", false, "report complexity, the longest functions and duplicated code too")
+	top      = flag.Int("top", 10, "rows of each -health table, 0 for all")
+	dupLines = flag.Int("dup-lines", 6, "least code lines of a -health duplicate")
+)
+
+var userLanguages = flag.String("languages", "", "JSON file with more languages or overrides, as languages.json; "+
+	"$XDG_CONFIG_HOME/tally/languages.json is read too if it exists")
+
 func main() {
-	if len(os.Args) < 2 {
-		fmt.Println("Usage: tally <path>")
+	flag.Usage = func() {
+		fmt.Fprintln(flag.CommandLine.Output(), "Usage: tally [flags] <path>")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() < 1 {
+		flag.Usage()
+		os.Exit(1)
+	}
+
+	// fail before counting
+	if err := (report{}).print(io.Discard, *format); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	if err := sortItems(nil, *sortBy); err != nil {
+		fmt.Fprintln(os.Stderr, err)
 		os.Exit(1)
 	}
 
-	process(os.Args[1])
+	if *health {
+		switch {
+		case *gitMode:
+			fmt.Fprintln(os.Stderr, "-health can't be used with -git")
+			os.Exit(1)
+		case *format == "csv":
+			fmt.Fprintln(os.Stderr, "-health has no csv output")
+			os.Exit(1)
+		case *dupLines < 1:
+			fmt.Fprintln(os.Stderr, "-dup-lines must be at least 1")
+			os.Exit(1)
+		}
+	}
 
-	result.String()
+	var sinceTime time.Time
+	if *gitMode {
+		if steps[*step] == nil {
+			fmt.Fprintf(os.Stderr, "unknown step %q\n", *step)
+			os.Exit(1)
+		}
+		if metrics[*metric] == nil {
+			fmt.Fprintf(os.Stderr, "unknown metric %q\n", *metric)
+			os.Exit(1)
+		}
+		if *since != "" {
+			t, err := time.ParseInLocation("2006-01-02", *since, time.Local)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "invalid -since: %v\n", err)
+				os.Exit(1)
+			}
+			sinceTime = t
+		}
+	}
+
+	var overrides []string
+	if dir, err := os.UserConfigDir(); err == nil {
+		if path := filepath.Join(dir, "tally", "languages.json"); fileExists(path) {
+			overrides = append(overrides, path)
+		}
+	}
+	if *userLanguages != "" {
+		overrides = append(overrides, *userLanguages)
+	}
+
+	if err := loadLanguages(overrides...); err != nil {
+		fmt.Fprintf(os.Stderr, "failed to load languages: %v\n", err)
+		os.Exit(1)
+	}
+
+	result = &Result{
+		data: make([]Item, registedNum),
+	}
+
+	if *gitMode {
+		samples, err := history(flag.Arg(0), sinceTime, steps[*step])
+		if err == nil {
+			err = printHistory(os.Stdout, samples, *format, *metric)
+		}
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	process(flag.Arg(0))
+
+	rep, err := result.report(*perFile, *sortBy)
+	if *health {
+		rep.health = result.healthReport(*dupLines, *top)
+	}
+	if err == nil {
+		err = rep.print(os.Stdout, *format)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
 }
 
 type Item struct {
+	// path is set for the items of single files
+	path    string
 	lang    string
 	files   int
 	lines   int
@@ -123,77 +319,21 @@ func mergeItem(a, b Item) Item {
 }
 
 type Result struct {
-	mu   sync.Mutex
-	data []Item
+	mu     sync.Mutex
+	data   []Item
+	files  []Item
+	health []fileHealth
 }
 
-func (r *Result) Add(c Counter, item Item) {
+func (r *Result) Add(c Counter, path string, item Item) {
 	r.mu.Lock()
 	defer r.mu.Unlock()
 	r.data[c.idx-1] = mergeItem(r.data[c.idx-1], item)
-}
 
-func (r *Result) String() {
-	itemF := " %-10s %10d %10d %10d %10d %10d \n"
-	headerF := " %-10s %10s %10s %10s %10s %10s \n"
-	borderLen := 67
-	fmt.Printf(strings.Repeat("━", borderLen) + "\n")
-	fmt.Printf(headerF, "Language", "Files", "Lines", "Code", "Comments", "Blanks")
-	fmt.Printf(strings.Repeat("━", borderLen) + "\n")
-
-	var total Item
-
-	sort.Slice(r.data, func(i, j int) bool {
-		return r.data[i].lines > r.data[j].lines
-	})
-	for _, item := range r.data {
-		if item.files == 0 {
-			continue
-		}
-
-		total = mergeItem(total, item)
-		fmt.Printf(itemF, item.lang, item.files, item.lines, item.code, item.comment, item.blank)
-	}
-
-	fmt.Printf(strings.Repeat("━", borderLen) + "\n")
-	fmt.Printf(itemF, "Total", total.files, total.lines, total.code, total.comment, total.blank)
-	fmt.Printf(strings.Repeat("━", borderLen) + "\n")
-}
-
-func (c Counter) isComment(s []byte) bool {
-	return bytes.HasPrefix(s, []byte(c.comment))
-}
-
-func (c Counter) isMultiLineComment(s []byte) int {
-	if c.multiLineComment == nil {
-		return 0
-	}
-
-	if bytes.HasPrefix(s, []byte(c.multiLineComment[0])) {
-		return -1
-	}
-
-	if bytes.HasPrefix(s, []byte(c.multiLineComment[1])) {
-		return 1
-	}
-
-	return 0
-}
-
-func (c Counter) toMultiLineCommentEnd(scanner *bufio.Scanner) int {
-	var i int
-	for scanner.Scan() {
-		if n := c.isMultiLineComment(scanner.Bytes()); n != 1 {
-			i++
-			continue
-		}
-		return i
+	if *perFile {
+		item.path = path
+		r.files = append(r.files, item)
 	}
-	return i
-}
-
-func guessLang(file string) Counter {
-	return ext2Counter[filepath.Ext(file)]
 }
 
 func countLine(path string) error {
@@ -201,15 +341,22 @@ func countLine(path string) error {
 	if err != nil {
 		return err
 	}
-	scanner := bufio.NewScanner(bytes.NewReader(f))
 
-	if err != nil {
-		return err
+	if c, item, ok := count(path, f); ok {
+		result.Add(c, path, item)
+		if *health {
+			result.AddHealth(analyze(c, path, f))
+		}
 	}
+	return nil
+}
 
-	c := guessLang(path)
+// count counts the lines of a file, ok is false if it is binary or of no
+// known language.
+func count(path string, content []byte) (Counter, Item, bool) {
+	c := guessLang(path, content)
 	if c.lang == "" {
-		return nil
+		return c, Item{}, false
 	}
 
 	item := Item{
@@ -217,38 +364,32 @@ func countLine(path string) error {
 		files: 1,
 	}
 
+	scanner := bufio.NewScanner(bytes.NewReader(content))
+	scanner.Buffer(nil, len(content)+1)
+
+	var st lineState
 	for scanner.Scan() {
 		if isBinary(scanner.Bytes()) {
-			return nil
+			return c, Item{}, false
 		}
 
 		item.lines++
-		line := bytes.TrimSpace(scanner.Bytes())
-
-		if len(line) == 0 {
+		switch c.classify(scanner.Bytes(), &st) {
+		case blankLine:
 			item.blank++
-			continue
-		}
-
-		if c.isComment(line) {
+		case commentLine:
 			item.comment++
-			continue
-		} else if n := c.isMultiLineComment(line); n == -1 {
-			l := c.toMultiLineCommentEnd(scanner)
-			item.comment += l + 2
-			item.lines += l + 1
-			continue
+		default:
+			item.code++
 		}
-
-		item.code++
 	}
 
-	result.Add(c, item)
-	return nil
+	return c, item, true
 }
 
-func vec(s ...string) []string {
-	return s
+func fileExists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
 }
 
 func or(a, b string) string {
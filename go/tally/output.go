@@ -0,0 +1,367 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+var formats = []string{"table", "json", "csv", "yaml", "markdown"}
+
+// sortColumns are what -sort takes, counts sort the largest first.
+var sortColumns = []string{"path", "language", "files", "lines", "code", "comments", "blanks"}
+
+// report is what gets printed: the languages, or the files with -files,
+// and their total.
+type report struct {
+	files bool
+	rows  []Item
+	total Item
+	// with -health
+	health *healthReport
+}
+
+func (r *Result) report(files bool, by string) (report, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	rep := report{files: files}
+	for _, item := range r.data {
+		if item.files == 0 {
+			continue
+		}
+		rep.total = mergeItem(rep.total, item)
+		if !files {
+			rep.rows = append(rep.rows, item)
+		}
+	}
+	if files {
+		rep.rows = append(rep.rows, r.files...)
+	}
+	rep.total.lang = "Total"
+
+	if err := sortItems(rep.rows, by); err != nil {
+		return rep, err
+	}
+	return rep, nil
+}
+
+func sortItems(items []Item, by string) error {
+	var less func(a, b Item) bool
+	switch by {
+	case "path":
+		less = func(a, b Item) bool { return a.path < b.path }
+	case "language":
+		less = func(a, b Item) bool { return a.lang < b.lang }
+	default:
+		count := map[string]func(Item) int{
+			"files":    func(it Item) int { return it.files },
+			"lines":    func(it Item) int { return it.lines },
+			"code":     func(it Item) int { return it.code },
+			"comments": func(it Item) int { return it.comment },
+			"blanks":   func(it Item) int { return it.blank },
+		}[by]
+		if count == nil {
+			return fmt.Errorf("can't sort by %q, want one of %s", by, strings.Join(sortColumns, ", "))
+		}
+		less = func(a, b Item) bool { return count(a) > count(b) }
+	}
+
+	sort.SliceStable(items, func(i, j int) bool {
+		a, b := items[i], items[j]
+		if less(a, b) || less(b, a) {
+			return less(a, b)
+		}
+		if a.lang != b.lang {
+			return a.lang < b.lang
+		}
+		return a.path < b.path
+	})
+	return nil
+}
+
+// names is the number of name columns before the counts.
+func (rep report) names() int {
+	if rep.files {
+		return 2
+	}
+	return 1
+}
+
+func (rep report) header() []string {
+	if rep.files {
+		return []string{"File", "Language", "Lines", "Code", "Comments", "Blanks"}
+	}
+	return []string{"Language", "Files", "Lines", "Code", "Comments", "Blanks"}
+}
+
+// cells returns the names and the counts of a row.
+func (rep report) cells(it Item, total bool) ([]string, []int) {
+	counts := []int{it.lines, it.code, it.comment, it.blank}
+	switch {
+	case rep.files && total:
+		return []string{"Total", strconv.Itoa(it.files) + " files"}, counts
+	case rep.files:
+		return []string{it.path, it.lang}, counts
+	default:
+		return []string{it.lang}, append([]int{it.files}, counts...)
+	}
+}
+
+func (rep report) print(w io.Writer, format string) error {
+	switch format {
+	case "table":
+		rep.printTable(w)
+		rep.printSections(w, section.printTable)
+		return nil
+	case "json":
+		return rep.printJSON(w)
+	case "csv":
+		if rep.health != nil {
+			return errors.New("-health has no csv output")
+		}
+		return rep.printCSV(w)
+	case "yaml":
+		rep.printYAML(w)
+		if rep.health != nil {
+			rep.health.json().printYAML(w)
+		}
+		return nil
+	case "markdown":
+		rep.printMarkdown(w)
+		rep.printSections(w, section.printMarkdown)
+		return nil
+	default:
+		return fmt.Errorf("unknown format %q, want one of %s", format, strings.Join(formats, ", "))
+	}
+}
+
+func (rep report) printSections(w io.Writer, print func(section, io.Writer)) {
+	if rep.health == nil {
+		return
+	}
+	for _, s := range rep.health.sections() {
+		fmt.Fprintln(w)
+		print(s, w)
+	}
+}
+
+func (rep report) printTable(w io.Writer) {
+	header, names := rep.header(), rep.names()
+
+	// name columns as wide as their longest value
+	widths := make([]int, names)
+	for i := range widths {
+		widths[i] = max(14, len(header[i]))
+	}
+	fit := func(cells []string, _ []int) {
+		for i, cell := range cells {
+			widths[i] = max(widths[i], len(cell))
+		}
+	}
+	for _, it := range rep.rows {
+		fit(rep.cells(it, false))
+	}
+	fit(rep.cells(rep.total, true))
+
+	borderLen := 1 + len(header)*11
+	for _, width := range widths {
+		borderLen += width - 10
+	}
+	border := strings.Repeat("━", borderLen) + "\n"
+
+	line := func(cells []string, counts []int) {
+		for i, cell := range cells {
+			fmt.Fprintf(w, " %-*s", widths[i], cell)
+		}
+		for _, n := range counts {
+			fmt.Fprintf(w, " %10d", n)
+		}
+		fmt.Fprintln(w, " ")
+	}
+
+	fmt.Fprint(w, border)
+	for i, h := range header {
+		if i < names {
+			fmt.Fprintf(w, " %-*s", widths[i], h)
+		} else {
+			fmt.Fprintf(w, " %10s", h)
+		}
+	}
+	fmt.Fprintln(w, " ")
+	fmt.Fprint(w, border)
+	for _, it := range rep.rows {
+		line(rep.cells(it, false))
+	}
+	fmt.Fprint(w, border)
+	line(rep.cells(rep.total, true))
+	fmt.Fprint(w, border)
+}
+
+type jsonItem struct {
+	Path     string `json:"path,omitempty"`
+	Language string `json:"language,omitempty"`
+	Files    int    `json:"files,omitempty"`
+	Lines    int    `json:"lines"`
+	Code     int    `json:"code"`
+	Comments int    `json:"comments"`
+	Blanks   int    `json:"blanks"`
+}
+
+func (rep report) jsonItem(it Item, total bool) jsonItem {
+	j := jsonItem{
+		Path:     it.path,
+		Language: it.lang,
+		Files:    it.files,
+		Lines:    it.lines,
+		Code:     it.code,
+		Comments: it.comment,
+		Blanks:   it.blank,
+	}
+	if total {
+		j.Language = ""
+	} else if rep.files {
+		j.Files = 0
+	}
+	return j
+}
+
+func (rep report) printJSON(w io.Writer) error {
+	rows := make([]jsonItem, 0, len(rep.rows))
+	for _, it := range rep.rows {
+		rows = append(rows, rep.jsonItem(it, false))
+	}
+
+	out := map[string]any{"total": rep.jsonItem(rep.total, true)}
+	if rep.files {
+		out["files"] = rows
+	} else {
+		out["languages"] = rows
+	}
+	if rep.health != nil {
+		out["health"] = rep.health.json()
+	}
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(out)
+}
+
+func (rep report) printCSV(w io.Writer) error {
+	cw := csv.NewWriter(w)
+	cw.Write(lower(rep.header()))
+
+	row := func(cells []string, counts []int) {
+		for _, n := range counts {
+			cells = append(cells, strconv.Itoa(n))
+		}
+		cw.Write(cells)
+	}
+	for _, it := range rep.rows {
+		row(rep.cells(it, false))
+	}
+	row(rep.cells(rep.total, true))
+
+	cw.Flush()
+	return cw.Error()
+}
+
+func (rep report) printYAML(w io.Writer) {
+	key := "languages"
+	if rep.files {
+		key = "files"
+	}
+
+	if len(rep.rows) == 0 {
+		fmt.Fprintf(w, "%s: []\n", key)
+	} else {
+		fmt.Fprintf(w, "%s:\n", key)
+	}
+	for _, it := range rep.rows {
+		for i, kv := range yamlFields(rep.jsonItem(it, false)) {
+			prefix := "    "
+			if i == 0 {
+				prefix = "  - "
+			}
+			fmt.Fprintf(w, "%s%s: %s\n", prefix, kv[0], kv[1])
+		}
+	}
+
+	fmt.Fprintln(w, "total:")
+	for _, kv := range yamlFields(rep.jsonItem(rep.total, true)) {
+		fmt.Fprintf(w, "  %s: %s\n", kv[0], kv[1])
+	}
+}
+
+// yamlFields are the fields of j as JSON has them, strings quoted.
+func yamlFields(j jsonItem) [][2]string {
+	var fields [][2]string
+	if j.Path != "" {
+		fields = append(fields, [2]string{"path", strconv.Quote(j.Path)})
+	}
+	if j.Language != "" {
+		fields = append(fields, [2]string{"language", strconv.Quote(j.Language)})
+	}
+	if j.Files != 0 {
+		fields = append(fields, [2]string{"files", strconv.Itoa(j.Files)})
+	}
+	return append(fields,
+		[2]string{"lines", strconv.Itoa(j.Lines)},
+		[2]string{"code", strconv.Itoa(j.Code)},
+		[2]string{"comments", strconv.Itoa(j.Comments)},
+		[2]string{"blanks", strconv.Itoa(j.Blanks)},
+	)
+}
+
+func (rep report) printMarkdown(w io.Writer) {
+	header := rep.header()
+	fmt.Fprintf(w, "| %s |\n", strings.Join(header, " | "))
+	for i := range header {
+		if i < rep.names() {
+			fmt.Fprint(w, "| --- ")
+		} else {
+			fmt.Fprint(w, "| ---: ")
+		}
+	}
+	fmt.Fprintln(w, "|")
+
+	row := func(cells []string, counts []int, bold bool) {
+		for i, cell := range cells {
+			cell = strings.ReplaceAll(cell, "|", `\|`)
+			if bold && i == 0 {
+				cell = "**" + cell + "**"
+			}
+			cells[i] = cell
+		}
+		for _, n := range counts {
+			cells = append(cells, strconv.Itoa(n))
+		}
+		fmt.Fprintf(w, "| %s |\n", strings.Join(cells, " | "))
+	}
+	for _, it := range rep.rows {
+		names, counts := rep.cells(it, false)
+		row(names, counts, false)
+	}
+	names, counts := rep.cells(rep.total, true)
+	row(names, counts, true)
+}
+
+func lower(s []string) []string {
+	out := make([]string, len(s))
+	for i, v := range s {
+		out[i] = strings.ToLower(v)
+	}
+	return out
+}
+
+func max(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,6 @@
+:: comment
+REM comment
+rem lower case
+echo hello
+
+set X=1
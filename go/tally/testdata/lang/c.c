@@ -0,0 +1,13 @@
+#include <stdio.h>
+
+/* a block comment
+
+   across lines */
+int main(void) { /* code then comment */
+	printf("/* not a comment */ // nor this\n");
+	char c = '"'; // a quote in a char
+	// line comment
+	return 0; /* trailing
+	still comment */
+	/* comment */ int x = 1;
+}
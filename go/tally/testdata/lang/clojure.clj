@@ -0,0 +1,6 @@
+; comment
+;; another
+(def s "a
+; in string")
+
+(println s) ; trailing
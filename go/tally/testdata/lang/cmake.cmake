@@ -0,0 +1,7 @@
+# comment
+#[[ block
+comment ]]
+set(X "a
+# still a string")
+
+message(STATUS "${X}") # trailing
@@ -0,0 +1,10 @@
+#include <string>
+// C++ has raw strings
+auto s = R"(/* not
+a comment */)";
+std::string t = "// nor \" this";
+/*
+ * doc
+ */
+
+int f() { return 1; }
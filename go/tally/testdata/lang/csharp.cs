@@ -0,0 +1,8 @@
+// verbatim strings
+var path = @"C:\dir\"; // no escapes
+var s = """
+/* raw */
+""";
+/* block */
+
+class A {}
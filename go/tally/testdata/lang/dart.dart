@@ -0,0 +1,8 @@
+/* outer /* inner */
+   still comment */
+var s = '/* no */';
+var t = """
+// in string
+""";
+
+// done
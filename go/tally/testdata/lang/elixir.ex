@@ -0,0 +1,8 @@
+# comment
+defmodule A do
+  @doc """
+  # not a comment
+  """
+  def f, do: "# x"
+end
+
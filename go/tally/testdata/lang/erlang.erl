@@ -0,0 +1,6 @@
+% comment
+%% module doc
+-module(a).
+f() -> "% not
+a comment".
+
@@ -0,0 +1,4 @@
+# comment
+echo "# no \" still" '# raw \'
+
+set x 1 # trailing
@@ -0,0 +1,11 @@
+package main
+
+/*
+block comment
+*/
+var s = `/* raw
+// string`
+var t = "\" // still string"
+
+// line
+func f() {} // trailing
@@ -0,0 +1,7 @@
+// comment
+def s = '''
+/* not */
+'''
+def t = "/* x */" /* trailing */
+/* block
+*/
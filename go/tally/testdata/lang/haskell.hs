@@ -0,0 +1,5 @@
+-- line
+{- outer {- inner -}
+   still -}
+main = putStrLn "-- not {- a comment"
+
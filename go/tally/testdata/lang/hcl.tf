@@ -0,0 +1,6 @@
+# hash
+// slashes
+/* block
+*/
+name = "# not // a comment"
+
@@ -0,0 +1,10 @@
+/**
+ * Javadoc
+ */
+class A {
+  String s = """
+    /* text block */
+    """;
+  char c = '\''; // escaped quote
+
+}
@@ -0,0 +1,6 @@
+// comment
+const s = `multi
+// line template`;
+const r = "/*" + '*/'; /* trailing */
+/* block */
+
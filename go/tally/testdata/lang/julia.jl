@@ -0,0 +1,6 @@
+#= outer #= inner =#
+   still =#
+s = """
+# in string
+"""
+# line
@@ -0,0 +1,5 @@
+/* a /* b */ c */
+val p = """C:\dir\"""
+val s = "/* no */"
+// end
+
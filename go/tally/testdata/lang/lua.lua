@@ -0,0 +1,7 @@
+-- line
+--[[ block
+comment ]]
+local s = [[
+-- in long string
+]]
+local t = "--no" -- trailing
@@ -0,0 +1,4 @@
+# comment
+all:
+	echo hi # trailing
+
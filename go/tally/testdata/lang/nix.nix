@@ -0,0 +1,7 @@
+# line
+/* block */
+{
+  s = ''
+    # in string
+  '';
+}
@@ -0,0 +1,5 @@
+#import <Foundation/Foundation.h>
+// comment
+NSString *s = @"/* no */";
+/* block
+*/
@@ -0,0 +1,5 @@
+(* outer (* inner *)
+   still *)
+let s = "(* not
+a comment *)"
+
@@ -0,0 +1,6 @@
+# comment
+my $s = "# not";
+=pod
+Docs here
+=cut
+print $s; # trailing
@@ -0,0 +1,7 @@
+<?php
+# hash
+// slashes
+$s = "multi
+# line";
+$t = 'a # b'; /* trailing */
+
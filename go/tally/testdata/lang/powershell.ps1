@@ -0,0 +1,6 @@
+<# block
+comment #>
+# line
+$s = "multi
+# line"
+$t = 'C:\#'
@@ -0,0 +1,12 @@
+"""Module docstring
+spanning lines."""
+import os
+
+def f():
+    '''Function docstring.'''
+    s = """not a docstring
+# still a string
+"""
+    t = "# not a comment"
+    # a comment
+    return s + t  # trailing
@@ -0,0 +1,4 @@
+# comment
+s <- "a
+# b"
+x <- 1 # trailing
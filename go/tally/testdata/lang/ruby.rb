@@ -0,0 +1,7 @@
+# comment
+=begin
+block
+=end
+s = "# not
+a comment"
+t = 'x' # trailing
@@ -0,0 +1,9 @@
+/* outer /* inner */
+   still outer */
+fn main() {
+    let s = "/* not a comment";
+    let r = r#"// "raw" "#;
+    // line
+    let m = "multi
+// line string";
+}
@@ -0,0 +1,4 @@
+/* a /* b */ c */
+val s = """
+// text
+"""
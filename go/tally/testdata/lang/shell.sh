@@ -0,0 +1,5 @@
+#!/bin/sh
+# comment
+echo "# not a comment" '# raw'
+
+x=1 # trailing
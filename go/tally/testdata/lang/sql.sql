@@ -0,0 +1,4 @@
+-- comment
+SELECT '-- not a comment'
+FROM t /* trailing */
+/* block */
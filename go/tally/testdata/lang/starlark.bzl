@@ -0,0 +1,6 @@
+"""Docstring."""
+load("//x:y.bzl", "z")
+# comment
+s = """
+# in string
+"""
@@ -0,0 +1,5 @@
+/* a /* b */ c */
+let s = """
+// text
+"""
+let t = "/*" // trailing
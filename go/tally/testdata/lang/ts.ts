@@ -0,0 +1,6 @@
+/* block */
+const s: string = `
+// template
+`;
+// line
+let x = 1; // trailing
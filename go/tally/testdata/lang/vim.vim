@@ -0,0 +1,4 @@
+" comment
+set nocompatible
+
+let s = 1
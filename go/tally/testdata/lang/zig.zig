@@ -0,0 +1,4 @@
+// comment
+/// doc comment
+const s = "// no";
+const c = '\'';